@@ -0,0 +1,168 @@
+package addon
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"gopkg.in/yaml.v2"
+	"sigs.k8s.io/kustomize/api/filesys"
+
+	"github.com/shark/hcloud-k3os-configurator/kustomize"
+	"github.com/shark/hcloud-k3os-configurator/model"
+)
+
+const (
+	// ManifestFile is the name of the manifest in the root directory of a bundle
+	ManifestFile = "addon.yaml"
+
+	// TemplateSuffix marks the files of a bundle which are rendered with its parameters, foo.yaml.tmpl is rendered to foo.yaml
+	TemplateSuffix = ".tmpl"
+)
+
+// Manifest describes an addon bundle
+type Manifest struct {
+	Name    string `yaml:"name"`
+	Version string `yaml:"version"`
+	// Parameters maps the names used in the templates to config fields, e.g. token: cluster_config.hcloud_token
+	Parameters map[string]string `yaml:"parameters"`
+}
+
+// Bundle is a directory with a manifest, a kustomization and the templates of an addon
+type Bundle struct {
+	Manifest
+	Dir string
+	// Source is where the bundle was loaded from
+	Source string
+	// Checksum is the SHA-256 of the paths and contents of the bundle's files
+	Checksum string
+}
+
+func (b *Bundle) String() string {
+	return fmt.Sprintf("%s %s (sha256 %s) from %s", b.Name, b.Version, b.Checksum, b.Source)
+}
+
+// Load reads the bundle in dir, source describes where it came from
+func Load(dir, source string) (*Bundle, error) {
+	var (
+		b   = &Bundle{Dir: dir, Source: source}
+		buf []byte
+		err error
+	)
+	if buf, err = ioutil.ReadFile(filepath.Join(dir, ManifestFile)); err != nil {
+		return nil, fmt.Errorf("error reading manifest of bundle %s: %w", source, err)
+	}
+	if err = yaml.UnmarshalStrict(buf, &b.Manifest); err != nil {
+		return nil, fmt.Errorf("error unmarshalling manifest of bundle %s: %w", source, err)
+	}
+	if len(b.Name) == 0 || len(b.Version) == 0 {
+		return nil, fmt.Errorf("manifest of bundle %s has no name or version", source)
+	}
+	for name, field := range b.Parameters {
+		if _, ok := fields[field]; !ok {
+			return nil, fmt.Errorf("parameter '%s' of bundle %s refers to unknown config field '%s'", name, source, field)
+		}
+	}
+	if b.Checksum, err = checksum(dir); err != nil {
+		return nil, fmt.Errorf("error computing checksum of bundle %s: %w", source, err)
+	}
+	return b, nil
+}
+
+// checksum returns the SHA-256 of the paths and contents of the regular files below dir
+func checksum(dir string) (string, error) {
+	h := sha256.New()
+	err := filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("bundle contains symlink '%s'", p)
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		buf, err := ioutil.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(h, "%s\x00%d\x00", filepath.ToSlash(rel), len(buf))
+		h.Write(buf)
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Build renders the templates of the bundle with the parameters from cfg in memory and builds its kustomization
+func (b *Bundle) Build(cfg *model.HCloudK3OSConfig) ([]byte, error) {
+	var (
+		params = make(map[string]interface{}, len(b.Parameters))
+		fSys   = filesys.MakeFsInMemory()
+		root   = path.Join("/", b.Name)
+	)
+	for name, field := range b.Parameters {
+		params[name] = fields[field](cfg)
+	}
+
+	err := filepath.Walk(b.Dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(b.Dir, p)
+		if err != nil {
+			return err
+		}
+		dest := path.Join(root, filepath.ToSlash(rel))
+		if info.IsDir() {
+			return fSys.MkdirAll(dest)
+		}
+		buf, err := ioutil.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		if strings.HasSuffix(dest, TemplateSuffix) {
+			dest = strings.TrimSuffix(dest, TemplateSuffix)
+			if buf, err = render(rel, buf, params); err != nil {
+				return err
+			}
+		}
+		return fSys.WriteFile(dest, buf)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error preparing bundle %s: %w", b.Name, err)
+	}
+	return kustomize.Build(fSys, root)
+}
+
+// render executes the template tmpl named name with params
+func render(name string, tmpl []byte, params map[string]interface{}) ([]byte, error) {
+	t, err := template.New(name).Option("missingkey=error").Funcs(template.FuncMap{
+		"json": func(v interface{}) (string, error) {
+			buf, err := json.Marshal(v)
+			return string(buf), err
+		},
+	}).Parse(string(tmpl))
+	if err != nil {
+		return nil, fmt.Errorf("error parsing template %s: %w", name, err)
+	}
+	var buf bytes.Buffer
+	if err = t.Execute(&buf, params); err != nil {
+		return nil, fmt.Errorf("error executing template %s: %w", name, err)
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,244 @@
+package addon
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/shark/hcloud-k3os-configurator/download"
+	"github.com/shark/hcloud-k3os-configurator/kustomize"
+	"github.com/shark/hcloud-k3os-configurator/model"
+)
+
+// DefaultLocalDir is the directory on the node bundles are loaded from, as directories or .tar.gz archives
+const DefaultLocalDir = "/var/lib/hcloud-k3os/addons"
+
+// Loader finds the bundle of an addon, bundles from the config take precedence over local bundles, which take precedence over the bundles in the binary
+type Loader struct {
+	log      *logrus.Logger
+	client   *http.Client
+	workDir  string
+	localDir string
+
+	mu      sync.Mutex
+	builtin map[string]*Bundle
+	// archives are the extracted bundle archives by their SHA-256
+	archives map[string]*Bundle
+}
+
+// NewLoader creates a Loader which downloads the bundles from the config with client, extracts bundles to workDir and looks for local bundles in localDir
+func NewLoader(log *logrus.Logger, client *http.Client, workDir, localDir string) *Loader {
+	return &Loader{
+		log:      log,
+		client:   client,
+		workDir:  workDir,
+		localDir: localDir,
+		archives: map[string]*Bundle{},
+	}
+}
+
+// Bundle returns the bundle of the addon name, sources are the bundles from the config. The sources are tried in order until one provides
+// the addon, a source which fails to load is logged and skipped
+func (l *Loader) Bundle(ctx context.Context, name string, sources []*model.AddonBundle) (*Bundle, error) {
+	var (
+		found *Bundle
+		err   error
+	)
+	for _, src := range sources {
+		var b *Bundle
+		if b, err = l.remote(ctx, src); err != nil {
+			l.log.WithError(err).Warnf("Skipping addon bundle %s", src.URL)
+			continue
+		}
+		if b.Name == name {
+			found = b
+			break
+		}
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if found == nil {
+		found = l.local(name)
+	}
+	if found == nil {
+		if err = l.extractBuiltin(); err != nil {
+			return nil, err
+		}
+		found = l.builtin[name]
+	}
+	if found == nil {
+		return nil, fmt.Errorf("no bundle for addon '%s'", name)
+	}
+	l.log.Infof("Using addon bundle %s", found)
+	return found, nil
+}
+
+// remote downloads the bundle archive of src unless it was downloaded before, the lock is not held while downloading
+func (l *Loader) remote(ctx context.Context, src *model.AddonBundle) (*Bundle, error) {
+	l.mu.Lock()
+	b, ok := l.archives[src.SHA256]
+	l.mu.Unlock()
+	if ok {
+		return b, nil
+	}
+	buf, err := download.Verified(ctx, l.client, src.URL, src.SHA256)
+	if err != nil {
+		return nil, fmt.Errorf("error downloading bundle: %w", err)
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.archive(buf, src.URL)
+}
+
+// local returns the bundle of the addon name in the local directory, or nil if there is none. Bundles which fail to load are logged and skipped
+func (l *Loader) local(name string) *Bundle {
+	entries, err := ioutil.ReadDir(l.localDir)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		l.log.WithError(err).Warnf("Skipping the local addon bundles in %s", l.localDir)
+		return nil
+	}
+	for _, entry := range entries {
+		var (
+			p = filepath.Join(l.localDir, entry.Name())
+			b *Bundle
+		)
+		switch {
+		case entry.IsDir():
+			b, err = Load(p, p)
+		case strings.HasSuffix(entry.Name(), ".tar.gz"):
+			var buf []byte
+			if buf, err = ioutil.ReadFile(p); err == nil {
+				b, err = l.archive(buf, p)
+			}
+		default:
+			continue
+		}
+		if err != nil {
+			l.log.WithError(err).Warnf("Skipping addon bundle %s", p)
+			continue
+		}
+		if b.Name == name {
+			return b
+		}
+	}
+	return nil
+}
+
+// extractBuiltin extracts the bundles in the binary unless they were extracted before
+func (l *Loader) extractBuiltin() error {
+	if l.builtin != nil {
+		return nil
+	}
+	dir := filepath.Join(l.workDir, "builtin")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("error creating directory for builtin bundles: %w", err)
+	}
+	if err := kustomize.Extract(dir); err != nil {
+		return fmt.Errorf("error extracting builtin bundles: %w", err)
+	}
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("error listing builtin bundles: %w", err)
+	}
+	builtin := map[string]*Bundle{}
+	for _, entry := range entries {
+		b, err := Load(filepath.Join(dir, entry.Name()), "builtin")
+		if err != nil {
+			return err
+		}
+		builtin[b.Name] = b
+	}
+	l.builtin = builtin
+	return nil
+}
+
+// archive extracts the bundle archive buf unless it was extracted before
+func (l *Loader) archive(buf []byte, source string) (*Bundle, error) {
+	sum := sha256.Sum256(buf)
+	key := hex.EncodeToString(sum[:])
+	if b, ok := l.archives[key]; ok {
+		return b, nil
+	}
+	dir := filepath.Join(l.workDir, "archives", key)
+	if err := os.RemoveAll(dir); err != nil {
+		return nil, fmt.Errorf("error cleaning up directory of bundle %s: %w", source, err)
+	}
+	if err := extractTarGz(buf, dir); err != nil {
+		return nil, fmt.Errorf("error extracting bundle %s: %w", source, err)
+	}
+	b, err := Load(dir, source)
+	if err != nil {
+		return nil, err
+	}
+	l.archives[key] = b
+	return b, nil
+}
+
+// extractTarGz extracts the directories and regular files of a .tar.gz archive to dest
+func extractTarGz(buf []byte, dest string) error {
+	gz, err := gzip.NewReader(bytes.NewReader(buf))
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+	if err = os.MkdirAll(dest, 0700); err != nil {
+		return err
+	}
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		name := path.Clean(hdr.Name)
+		if path.IsAbs(name) || name == ".." || strings.HasPrefix(name, "../") {
+			return fmt.Errorf("archive entry '%s' is outside of the bundle", hdr.Name)
+		}
+		p := filepath.Join(dest, filepath.FromSlash(name))
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			err = os.MkdirAll(p, 0700)
+		case tar.TypeReg:
+			err = extractFile(tr, p)
+		default:
+			return fmt.Errorf("archive entry '%s' is not a directory or regular file", hdr.Name)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func extractFile(r io.Reader, p string) error {
+	if err := os.MkdirAll(filepath.Dir(p), 0700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(p, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
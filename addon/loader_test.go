@@ -0,0 +1,157 @@
+package addon
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/shark/hcloud-k3os-configurator/model"
+)
+
+// tarGz returns a .tar.gz archive with the files by their path
+func tarGz(t *testing.T, files map[string]string) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for name, content := range files {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0600, Size: int64(len(content)), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// bundleServer serves the archives by path and counts the requests of each path
+type bundleServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	requests map[string]int
+}
+
+func newBundleServer(archives map[string][]byte) *bundleServer {
+	s := &bundleServer{requests: map[string]int{}}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		s.requests[r.URL.Path]++
+		s.mu.Unlock()
+		buf, ok := archives[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(buf)
+	}))
+	return s
+}
+
+// source returns the config of the bundle served at p with the checksum of buf
+func (s *bundleServer) source(p string, buf []byte) *model.AddonBundle {
+	sum := sha256.Sum256(buf)
+	return &model.AddonBundle{URL: s.URL + p, SHA256: hex.EncodeToString(sum[:])}
+}
+
+func testLoader(t *testing.T, tmp string) *Loader {
+	log := logrus.New()
+	log.SetOutput(ioutil.Discard)
+	return NewLoader(log, http.DefaultClient, filepath.Join(tmp, "work"), filepath.Join(tmp, "addons"))
+}
+
+func TestLoaderBundle(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "hcloud-k3os-addon-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	var (
+		ctx     = context.Background()
+		flux    = tarGz(t, map[string]string{ManifestFile: "name: flux\nversion: remote\n"})
+		other   = tarGz(t, map[string]string{ManifestFile: "name: other\nversion: remote\n"})
+		srv     = newBundleServer(map[string][]byte{"/flux.tar.gz": flux, "/other.tar.gz": other})
+		missing = srv.source("/missing.tar.gz", flux)
+	)
+	defer srv.Close()
+
+	// a broken archive, a directory without manifest and a valid bundle in the local directory
+	local := filepath.Join(tmp, "addons")
+	if err = os.MkdirAll(filepath.Join(local, "broken"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(filepath.Join(local, "broken.tar.gz"), []byte("not an archive"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.MkdirAll(filepath.Join(local, "sealed-secrets"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(filepath.Join(local, "sealed-secrets", ManifestFile), []byte("name: sealed-secrets\nversion: local\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		addon       string
+		sources     []*model.AddonBundle
+		wantVersion string
+		wantBuiltin bool
+		wantErr     bool
+	}{
+		{name: "remote", addon: "flux", sources: []*model.AddonBundle{srv.source("/flux.tar.gz", flux)}, wantVersion: "remote"},
+		{name: "failed remote is skipped", addon: "flux", sources: []*model.AddonBundle{missing, srv.source("/flux.tar.gz", flux)}, wantVersion: "remote"},
+		{name: "broken local bundles are skipped", addon: "sealed-secrets", sources: []*model.AddonBundle{missing}, wantVersion: "local"},
+		{name: "builtin", addon: "hcloud-csi", sources: []*model.AddonBundle{missing, srv.source("/other.tar.gz", other)}, wantBuiltin: true},
+		{name: "unknown", addon: "unknown", sources: []*model.AddonBundle{missing}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := testLoader(t, tmp).Bundle(ctx, tt.addon, tt.sources)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got %s", b)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if b.Name != tt.addon {
+				t.Errorf("got bundle %s, want %s", b, tt.addon)
+			}
+			if tt.wantBuiltin && b.Source != "builtin" {
+				t.Errorf("got bundle from %s, want the builtin one", b.Source)
+			}
+			if !tt.wantBuiltin && b.Version != tt.wantVersion {
+				t.Errorf("got version %s, want %s", b.Version, tt.wantVersion)
+			}
+		})
+	}
+
+	// the sources after the one providing the addon are not downloaded
+	srv.requests = map[string]int{}
+	if _, err = testLoader(t, tmp).Bundle(ctx, "flux", []*model.AddonBundle{srv.source("/flux.tar.gz", flux), srv.source("/other.tar.gz", other)}); err != nil {
+		t.Fatal(err)
+	}
+	if srv.requests["/other.tar.gz"] > 0 {
+		t.Error("a source after the one providing the addon was downloaded")
+	}
+}
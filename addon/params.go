@@ -0,0 +1,44 @@
+package addon
+
+import (
+	"github.com/shark/hcloud-k3os-configurator/model"
+)
+
+// fields are the config fields bundle parameters can be mapped to, named by their YAML path
+var fields = map[string]func(cfg *model.HCloudK3OSConfig) interface{}{
+	"node_config.name": func(cfg *model.HCloudK3OSConfig) interface{} { return cfg.NodeConfig.Name },
+	"node_config.role": func(cfg *model.HCloudK3OSConfig) interface{} { return string(cfg.NodeConfig.Role) },
+	"node_config.floating_ips": func(cfg *model.HCloudK3OSConfig) interface{} {
+		ips := []string{}
+		for _, fip := range cfg.NodeConfig.FloatingIPs {
+			ips = append(ips, fip.Net.IP.String())
+		}
+		return ips
+	},
+	"cluster_config.cluster_name": func(cfg *model.HCloudK3OSConfig) interface{} { return cfg.ClusterConfig.ClusterName },
+	"cluster_config.hcloud_token": func(cfg *model.HCloudK3OSConfig) interface{} { return cfg.ClusterConfig.HCloudToken },
+	"cluster_config.flux_config.git_url": func(cfg *model.HCloudK3OSConfig) interface{} {
+		if cfg.ClusterConfig.FluxConfig == nil {
+			return ""
+		}
+		return cfg.ClusterConfig.FluxConfig.GitURL
+	},
+	"cluster_config.flux_config.git_private_key": func(cfg *model.HCloudK3OSConfig) interface{} {
+		if cfg.ClusterConfig.FluxConfig == nil {
+			return ""
+		}
+		return cfg.ClusterConfig.FluxConfig.GitPrivateKey
+	},
+	"cluster_config.sealed_secrets_config.tls_cert": func(cfg *model.HCloudK3OSConfig) interface{} {
+		if cfg.ClusterConfig.SealedSecretsConfig == nil {
+			return ""
+		}
+		return cfg.ClusterConfig.SealedSecretsConfig.TLSCert
+	},
+	"cluster_config.sealed_secrets_config.tls_key": func(cfg *model.HCloudK3OSConfig) interface{} {
+		if cfg.ClusterConfig.SealedSecretsConfig == nil {
+			return ""
+		}
+		return cfg.ClusterConfig.SealedSecretsConfig.TLSKey
+	},
+}
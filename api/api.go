@@ -1,82 +1,61 @@
 package api
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
-	"errors"
 	"fmt"
-	"io/ioutil"
 	"net"
 	"net/http"
+	"net/url"
+	"regexp"
 	"strconv"
-	"time"
+	"strings"
 
 	"gopkg.in/yaml.v2"
-
-	"github.com/shark/hcloud-k3os-configurator/errorx"
 )
 
-const instanceMetadataBaseURL = "http://169.254.169.254"
-const hetznerAPIBaseURL = "https://api.hetzner.cloud/v1"
-
 // Client is the hcloud API client
 type Client struct {
-	hCloudToken string
-	httpClient  *http.Client
+	hCloudToken     string
+	httpClient      *http.Client
+	metadataBaseURL string
+	apiBaseURL      string
 }
 
 // NewClient returns a new client with the given hcloud token
-func NewClient(hCloudToken string) *Client {
-	return &Client{
-		hCloudToken: hCloudToken,
-		httpClient:  &http.Client{Timeout: 3 * time.Second},
-	}
-}
-
-// GetInstanceID retrieves the server's instance ID from the server metadata service
-func (c *Client) GetInstanceID() (string, error) {
-	resp, err := c.httpClient.Get(instanceMetadataBaseURL + "/hetzner/v1/metadata/instance-id")
-	if err != nil {
-		var neterr net.Error
-		if errors.As(err, &neterr) && (neterr.Timeout() || neterr.Temporary()) {
-			return "", &errorx.RetryableError{Message: "timeout or temporary error in HTTP request", Err: neterr}
+func NewClient(hCloudToken string, opts ...Option) (*Client, error) {
+	o := &options{
+		metadataBaseURL: DefaultMetadataBaseURL,
+		apiBaseURL:      DefaultAPIBaseURL,
+	}
+	for _, opt := range opts {
+		if err := opt(o); err != nil {
+			return nil, fmt.Errorf("error applying client option: %w", err)
 		}
-		return "", fmt.Errorf("error in http request: %w", err)
 	}
-	defer resp.Body.Close()
-	if resp.StatusCode != 200 {
-		err = fmt.Errorf("unexpected status code %d != 200", resp.StatusCode)
-		if resp.StatusCode >= 500 && resp.StatusCode < 600 {
-			return "", &errorx.RetryableError{Message: "retryable HTTP error", Err: err}
-		}
-		return "", err
-	}
-	instanceIDB, err := ioutil.ReadAll(resp.Body)
+	httpClient, err := httpClientFromOptions(o)
 	if err != nil {
-		return "", fmt.Errorf("error reading response body: %w", err)
+		return nil, fmt.Errorf("error creating HTTP client: %w", err)
 	}
-	return string(instanceIDB), nil
+	return &Client{
+		hCloudToken:     hCloudToken,
+		httpClient:      httpClient,
+		metadataBaseURL: o.metadataBaseURL,
+		apiBaseURL:      o.apiBaseURL,
+	}, nil
+}
+
+// GetInstanceID retrieves the server's instance ID from the server metadata service
+func (c *Client) GetInstanceID(ctx context.Context) (string, error) {
+	return c.getMetadata(ctx, "/hetzner/v1/metadata/instance-id")
 }
 
 // GetUserData retrieves the user data field from the server metadata service
-func (c *Client) GetUserData() (string, error) {
-	resp, err := c.httpClient.Get(instanceMetadataBaseURL + "/latest/user-data")
-	if err != nil {
-		var neterr net.Error
-		// TODO handle "dial tcp 169.254.169.254:80: connect: host is down" as a RetryableError
-		if errors.As(err, &neterr) && (neterr.Timeout() || neterr.Temporary()) {
-			return "", &errorx.RetryableError{Message: "timeout or temporary error in HTTP request", Err: neterr}
-		}
-		return "", fmt.Errorf("error in http request: %w", err)
-	}
-	defer resp.Body.Close()
-	if resp.StatusCode != 200 {
-		return "", fmt.Errorf("unexpected status code %d != 200", resp.StatusCode)
-	}
-	userDataB, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return "", fmt.Errorf("error reading response body: %w", err)
-	}
-	return string(userDataB), nil
+func (c *Client) GetUserData(ctx context.Context) (string, error) {
+	// TODO handle "dial tcp 169.254.169.254:80: connect: host is down" as a RetryableError
+	return c.getMetadata(ctx, "/latest/user-data")
 }
 
 // UserConfig represents the flux config in the user data
@@ -95,21 +74,52 @@ type UserConfig struct {
 	FluxGitURL        *string `yaml:"flux_git_url"`
 	FluxGitPrivateKey *string `yaml:"flux_git_private_key"`
 
+	// K3SNetwork is the ID or name of the private network used by k3s/flannel, the server label 'k3s_network' takes precedence
+	K3SNetwork string `yaml:"k3s_network"`
+
 	SealedSecretsTLSCert *string `yaml:"sealed_secrets_tls_cert"`
 	SealedSecretsTLSKey  *string `yaml:"sealed_secrets_tls_key"`
+
+	// AddonBundles are downloaded and take precedence over the bundles in the binary and on the node
+	AddonBundles []*AddonBundle `yaml:"addon_bundles"`
+
+	// ExtraManifests are written to the k3s manifests directory of master nodes
+	ExtraManifests []*ExtraManifest `yaml:"extra_manifests"`
+
+	// AddonPolicies are the policies of the addons by name, the server labels 'addon_<name>' take precedence
+	AddonPolicies map[string]string `yaml:"addon_policies"`
+}
+
+// ExtraManifest is a manifest with YAML documents, either inline or downloaded from an HTTPS URL with a SHA-256 checksum
+type ExtraManifest struct {
+	Name    string `yaml:"name"`
+	Content string `yaml:"content"`
+	URL     string `yaml:"url"`
+	SHA256  string `yaml:"sha256"`
+}
+
+// AddonBundle is the URL of an addon bundle archive and its SHA-256 checksum
+type AddonBundle struct {
+	URL    string `yaml:"url"`
+	SHA256 string `yaml:"sha256"`
 }
 
 // GetUserConfigFromUserData reads a user data string in YAML format and returns the flux config
-func (c *Client) GetUserConfigFromUserData() (*UserConfig, error) {
+func (c *Client) GetUserConfigFromUserData(ctx context.Context) (*UserConfig, error) {
 	var (
 		userDataStr string
 		err         error
 	)
-	if userDataStr, err = c.GetUserData(); err != nil {
+	if userDataStr, err = c.GetUserData(ctx); err != nil {
 		return nil, fmt.Errorf("error getting user data: %w", err)
 	}
+	return ParseUserConfig([]byte(userDataStr))
+}
+
+// ParseUserConfig parses and validates a user data document in YAML format
+func ParseUserConfig(buf []byte) (*UserConfig, error) {
 	var userData UserConfig
-	if err = yaml.Unmarshal([]byte(userDataStr), &userData); err != nil {
+	if err := yaml.Unmarshal(buf, &userData); err != nil {
 		return nil, fmt.Errorf("error unmarshalling YAML: %w", err)
 	}
 	if len(userData.HCloudToken) == 0 {
@@ -136,11 +146,49 @@ func (c *Client) GetUserConfigFromUserData() (*UserConfig, error) {
 	if (userData.SealedSecretsTLSCert != nil && userData.SealedSecretsTLSKey == nil) || (userData.SealedSecretsTLSKey != nil && userData.SealedSecretsTLSCert == nil) {
 		return nil, fmt.Errorf("invalid: sealed_secrets_tls_cert and sealed_secrets_tls_key must be both set or both be null")
 	}
+	for i, bundle := range userData.AddonBundles {
+		if err := validateDownload(bundle.URL, bundle.SHA256); err != nil {
+			return nil, fmt.Errorf("invalid: addon_bundles[%d]: %w", i, err)
+		}
+	}
+	names := map[string]bool{}
+	for i, manifest := range userData.ExtraManifests {
+		if !manifestNameRegexp.MatchString(manifest.Name) {
+			return nil, fmt.Errorf("invalid: extra_manifests[%d]: name '%s' must consist of lower case letters, digits and '-'", i, manifest.Name)
+		}
+		if names[manifest.Name] {
+			return nil, fmt.Errorf("invalid: extra_manifests[%d]: name '%s' is used more than once", i, manifest.Name)
+		}
+		names[manifest.Name] = true
+		if (len(manifest.Content) > 0) == (len(manifest.URL) > 0) {
+			return nil, fmt.Errorf("invalid: extra_manifests[%d]: exactly one of content and url must be set", i)
+		}
+		if len(manifest.URL) > 0 {
+			if err := validateDownload(manifest.URL, manifest.SHA256); err != nil {
+				return nil, fmt.Errorf("invalid: extra_manifests[%d]: %w", i, err)
+			}
+		}
+	}
 	return &userData, nil
 }
 
+// manifestNameRegexp matches valid names of extra manifests, they are used in file names
+var manifestNameRegexp = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`)
+
+// validateDownload checks that url is an HTTPS URL and checksum a hex encoded SHA-256
+func validateDownload(url, checksum string) error {
+	if !strings.HasPrefix(url, "https://") {
+		return fmt.Errorf("URL '%s' is not an HTTPS URL", url)
+	}
+	if buf, err := hex.DecodeString(checksum); err != nil || len(buf) != sha256.Size {
+		return fmt.Errorf("'%s' is not a hex encoded SHA-256 checksum", checksum)
+	}
+	return nil
+}
+
 // Server represents a Hetzner Cloud Server
 type Server struct {
+	ID              string
 	Name            string
 	IPv4Address     string
 	IPv6Subnet      string
@@ -182,164 +230,238 @@ type NetworkAssociation struct {
 	MACAddress string
 }
 
-// IPv4Net returns the IPv4 network of this network association
-func (n *NetworkAssociation) IPv4Net() (*net.IPNet, error) {
+// IPv4Net returns the IPv4 network of this network association, the prefix is taken from the subnet of network which contains the server IP
+func (n *NetworkAssociation) IPv4Net(network *Network) (*net.IPNet, error) {
 	var ip net.IP
-	if ip = net.ParseIP(n.ServerIP); ip == nil {
-		return nil, fmt.Errorf("error parsing ServerIP '%s'", ip)
+	if ip = net.ParseIP(n.ServerIP).To4(); ip == nil {
+		return nil, fmt.Errorf("error parsing ServerIP '%s'", n.ServerIP)
+	}
+	subnet, err := network.SubnetContaining(ip)
+	if err != nil {
+		return nil, err
 	}
 	return &net.IPNet{
 		IP:   ip,
-		Mask: net.CIDRMask(24, 32),
+		Mask: subnet.Mask,
 	}, nil
 }
 
-// GetServer fetches a server resource from the Hetzner API
-func (c *Client) GetServer(id string) (*Server, error) {
-	req, err := http.NewRequest("GET", hetznerAPIBaseURL+"/servers/"+id, nil)
-	if err != nil {
-		return nil, fmt.Errorf("error creating request: %w", err)
-	}
-	req.Header.Add("Authorization", "Bearer "+c.hCloudToken)
-	resp, err := c.httpClient.Do(req)
-	if err != nil {
-		var neterr net.Error
-		if errors.As(err, &neterr) && (neterr.Timeout() || neterr.Temporary()) {
-			return nil, &errorx.RetryableError{Message: "timeout or temporary error in HTTP request", Err: neterr}
-		}
-		return nil, fmt.Errorf("error in http request: %w", err)
-	}
-	defer resp.Body.Close()
-	if resp.StatusCode != 200 {
-		return nil, fmt.Errorf("unexpected status code %d != 200", resp.StatusCode)
-	}
-	var rawServer struct {
-		Server struct {
-			Name      string `json:"name"`
-			PublicNet struct {
-				IPv4 struct {
-					IP string `json:"ip"`
-				} `json:"ipv4"`
-				IPv6 struct {
-					IP string `json:"ip"`
-				} `json:"ipv6"`
-			} `json:"public_net"`
-			PrivateNet []struct {
-				ID         uint64 `json:"network"`
-				ServerIP   string `json:"ip"`
-				MACAddress string `json:"mac_address"`
-			} `json:"private_net"`
-			Labels map[string]string `json:"labels"`
-		} `json:"server"`
-	}
-	decoder := json.NewDecoder(resp.Body)
-	err = decoder.Decode(&rawServer)
-	if err != nil {
-		return nil, fmt.Errorf("error unmarshalling JSON: %w", err)
-	}
+// rawServer is the JSON representation of a server in the Hetzner API
+type rawServer struct {
+	ID        uint64 `json:"id"`
+	Name      string `json:"name"`
+	PublicNet struct {
+		IPv4 struct {
+			IP string `json:"ip"`
+		} `json:"ipv4"`
+		IPv6 struct {
+			IP string `json:"ip"`
+		} `json:"ipv6"`
+	} `json:"public_net"`
+	PrivateNet []struct {
+		ID         uint64 `json:"network"`
+		ServerIP   string `json:"ip"`
+		MACAddress string `json:"mac_address"`
+	} `json:"private_net"`
+	Labels map[string]string `json:"labels"`
+}
+
+// toServer converts the JSON representation to a Server
+func (r *rawServer) toServer() *Server {
 	server := Server{
-		Name:        rawServer.Server.Name,
-		IPv4Address: rawServer.Server.PublicNet.IPv4.IP,
-		IPv6Subnet:  rawServer.Server.PublicNet.IPv6.IP,
+		ID:          strconv.FormatUint(r.ID, 10),
+		Name:        r.Name,
+		IPv4Address: r.PublicNet.IPv4.IP,
+		IPv6Subnet:  r.PublicNet.IPv6.IP,
 		Labels:      map[string]string{},
 	}
-	for _, net := range rawServer.Server.PrivateNet {
+	for _, net := range r.PrivateNet {
 		server.PrivateNetworks = append(server.PrivateNetworks, &NetworkAssociation{
 			ID:         strconv.FormatUint(net.ID, 10),
 			ServerIP:   net.ServerIP,
 			MACAddress: net.MACAddress,
 		})
 	}
-	for k, v := range rawServer.Server.Labels {
+	for k, v := range r.Labels {
 		server.Labels[k] = v
 	}
-	return &server, nil
+	return &server
 }
 
-// GetServerWithRoleInCluster performs a search for a server with the label role and the given value and calls GetServer(id)
-func (c *Client) GetServerWithRoleInCluster(role string, cluster string) (*Server, error) {
-	req, err := http.NewRequest("GET", hetznerAPIBaseURL+"/servers", nil)
-	if err != nil {
-		return nil, fmt.Errorf("error creating request: %w", err)
+// GetServer fetches a server resource from the Hetzner API
+func (c *Client) GetServer(ctx context.Context, id string) (*Server, error) {
+	var buf json.RawMessage
+	if err := c.get(ctx, "/servers/"+id, nil, &buf); err != nil {
+		return nil, err
 	}
-	q := req.URL.Query()
-	q.Add("label_selector", fmt.Sprintf("cluster==%s,role==%s", cluster, role))
-	req.URL.RawQuery = q.Encode()
-	req.Header.Add("Authorization", "Bearer "+c.hCloudToken)
-	resp, err := c.httpClient.Do(req)
-	if err != nil {
-		var neterr net.Error
-		if errors.As(err, &neterr) && (neterr.Timeout() || neterr.Temporary()) {
-			return nil, &errorx.RetryableError{Message: "timeout or temporary error in HTTP request", Err: neterr}
-		}
-		return nil, fmt.Errorf("error in http request: %w", err)
+	return ParseServer(buf)
+}
+
+// ParseServer parses a server response of the Hetzner API
+func ParseServer(buf []byte) (*Server, error) {
+	var resp struct {
+		Server rawServer `json:"server"`
 	}
-	defer resp.Body.Close()
-	if resp.StatusCode != 200 {
-		return nil, fmt.Errorf("unexpected status code %d != 200", resp.StatusCode)
+	if err := json.Unmarshal(buf, &resp); err != nil {
+		return nil, fmt.Errorf("error unmarshalling JSON: %w", err)
 	}
-	var rawServers struct {
-		Servers []struct {
-			ID uint64 `json:"id"`
+	return resp.Server.toServer(), nil
+}
+
+// ParseServers parses a (page of a) server list response of the Hetzner API
+func ParseServers(buf []byte) ([]*Server, error) {
+	var (
+		servers []*Server
+		resp    struct {
+			Servers []rawServer `json:"servers"`
 		}
-	}
-	decoder := json.NewDecoder(resp.Body)
-	err = decoder.Decode(&rawServers)
-	if err != nil {
+	)
+	if err := json.Unmarshal(buf, &resp); err != nil {
 		return nil, fmt.Errorf("error unmarshalling JSON: %w", err)
 	}
-	if len(rawServers.Servers) != 1 {
+	for _, s := range resp.Servers {
+		servers = append(servers, s.toServer())
+	}
+	return servers, nil
+}
+
+// GetServerWithRoleInCluster performs a search for a server with the label role and the given value and calls GetServer(id)
+func (c *Client) GetServerWithRoleInCluster(ctx context.Context, role string, cluster string) (*Server, error) {
+	var ids []string
+	query := url.Values{}
+	query.Set("label_selector", labelSelector(map[string]string{"cluster": cluster, "role": role}))
+	if err := c.list(ctx, "/servers", query, func(page json.RawMessage) error {
+		servers, err := ParseServers(page)
+		if err != nil {
+			return err
+		}
+		for _, s := range servers {
+			ids = append(ids, s.ID)
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	if len(ids) != 1 {
 		return nil, fmt.Errorf("could not find a server with role %s", role)
 	}
-	server, err := c.GetServer(strconv.FormatUint(rawServers.Servers[0].ID, 10))
+	server, err := c.GetServer(ctx, ids[0])
 	if err != nil {
-		return nil, fmt.Errorf("error finding server with role %s (ID %d): %w", role, rawServers.Servers[0].ID, err)
+		return nil, fmt.Errorf("error finding server with role %s (ID %s): %w", role, ids[0], err)
 	}
 	return server, nil
 }
 
 // Network represents a Hetzner Cloud network
 type Network struct {
+	ID        string
+	Name      string
 	IPRange   string
 	GatewayIP string
+	Subnets   []*Subnet
+	Routes    []*Route
 }
 
-// GetNetwork fetches a network resource from the Hetzner API
-func (c *Client) GetNetwork(id string) (*Network, error) {
-	req, err := http.NewRequest("GET", hetznerAPIBaseURL+"/networks/"+id, nil)
-	if err != nil {
-		return nil, fmt.Errorf("error creating request: %w", err)
-	}
-	req.Header.Add("Authorization", "Bearer "+c.hCloudToken)
-	resp, err := c.httpClient.Do(req)
-	if err != nil {
-		var neterr net.Error
-		if errors.As(err, &neterr) && (neterr.Timeout() || neterr.Temporary()) {
-			return nil, &errorx.RetryableError{Message: "timeout or temporary error in HTTP request", Err: neterr}
+// Subnet is a subnet of a Hetzner Cloud network
+type Subnet struct {
+	// Type is one of cloud, server or vswitch
+	Type      string
+	IPRange   string
+	GatewayIP string
+	// VSwitchID is the ID of the dedicated server vSwitch for subnets of type vswitch, otherwise zero
+	VSwitchID uint64
+}
+
+// Route is a custom route of a Hetzner Cloud network
+type Route struct {
+	Destination string
+	GatewayIP   string
+}
+
+// SubnetContaining returns the range of the most specific subnet which contains ip
+func (n *Network) SubnetContaining(ip net.IP) (*net.IPNet, error) {
+	var found *net.IPNet
+	for _, subnet := range n.Subnets {
+		_, ipnet, err := net.ParseCIDR(subnet.IPRange)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing subnet IP range '%s': %w", subnet.IPRange, err)
+		}
+		if !ipnet.Contains(ip) {
+			continue
 		}
-		return nil, fmt.Errorf("error in http request: %w", err)
+		if found == nil || prefixLen(ipnet) > prefixLen(found) {
+			found = ipnet
+		}
+	}
+	if found == nil {
+		return nil, fmt.Errorf("no subnet of network '%s' contains IP '%s'", n.Name, ip)
 	}
-	defer resp.Body.Close()
-	if resp.StatusCode != 200 {
-		return nil, fmt.Errorf("unexpected status code %d != 200", resp.StatusCode)
+	return found, nil
+}
+
+// prefixLen returns the number of leading ones in the mask of ipnet
+func prefixLen(ipnet *net.IPNet) int {
+	ones, _ := ipnet.Mask.Size()
+	return ones
+}
+
+// GetNetwork fetches a network resource from the Hetzner API
+func (c *Client) GetNetwork(ctx context.Context, id string) (*Network, error) {
+	var buf json.RawMessage
+	if err := c.get(ctx, "/networks/"+id, nil, &buf); err != nil {
+		return nil, err
 	}
+	return ParseNetwork(buf)
+}
+
+// ParseNetwork parses a network response of the Hetzner API
+func ParseNetwork(buf []byte) (*Network, error) {
 	var rawNetwork struct {
 		Network struct {
+			ID      uint64 `json:"id"`
+			Name    string `json:"name"`
 			IPRange string `json:"ip_range"`
 			Subnets []struct {
-				Gateway string `json:"gateway"`
+				Type      string `json:"type"`
+				IPRange   string `json:"ip_range"`
+				Gateway   string `json:"gateway"`
+				VSwitchID uint64 `json:"vswitch_id"`
 			} `json:"subnets"`
+			Routes []struct {
+				Destination string `json:"destination"`
+				Gateway     string `json:"gateway"`
+			} `json:"routes"`
 		} `json:"network"`
 	}
-	decoder := json.NewDecoder(resp.Body)
-	err = decoder.Decode(&rawNetwork)
-	if err != nil {
+	if err := json.Unmarshal(buf, &rawNetwork); err != nil {
 		return nil, fmt.Errorf("error unmarshalling JSON: %w", err)
 	}
-	if len(rawNetwork.Network.Subnets) != 1 {
-		return nil, fmt.Errorf("invalid number of subnets for network %s: %d != 1", id, len(rawNetwork.Network.Subnets))
+	network := Network{
+		ID:      strconv.FormatUint(rawNetwork.Network.ID, 10),
+		Name:    rawNetwork.Network.Name,
+		IPRange: rawNetwork.Network.IPRange,
+	}
+	for _, rawSubnet := range rawNetwork.Network.Subnets {
+		network.Subnets = append(network.Subnets, &Subnet{
+			Type:      rawSubnet.Type,
+			IPRange:   rawSubnet.IPRange,
+			GatewayIP: rawSubnet.Gateway,
+			VSwitchID: rawSubnet.VSwitchID,
+		})
+		// all cloud subnets of a network share the gateway, vswitch subnets have the gateway of the vSwitch
+		if len(network.GatewayIP) == 0 && rawSubnet.Type != "vswitch" {
+			network.GatewayIP = rawSubnet.Gateway
+		}
+	}
+	if len(network.GatewayIP) == 0 {
+		return nil, fmt.Errorf("network %s does not have a cloud subnet with a gateway", network.ID)
+	}
+	for _, rawRoute := range rawNetwork.Network.Routes {
+		network.Routes = append(network.Routes, &Route{
+			Destination: rawRoute.Destination,
+			GatewayIP:   rawRoute.Gateway,
+		})
 	}
-	network := Network{IPRange: rawNetwork.Network.IPRange, GatewayIP: rawNetwork.Network.Subnets[0].Gateway}
 	return &network, nil
 }
 
@@ -388,39 +510,37 @@ func (f *FloatingIP) IPv6Net() (*net.IPNet, error) {
 }
 
 // GetFloatingIPsForCluster finds floating IPs that have a label with key 'cluster' and the given name
-func (c *Client) GetFloatingIPsForCluster(name string) ([]*FloatingIP, error) {
-	req, err := http.NewRequest("GET", hetznerAPIBaseURL+"/floating_ips", nil)
-	if err != nil {
-		return nil, fmt.Errorf("error creating request: %w", err)
-	}
-	q := req.URL.Query()
-	q.Add("label_selector", "cluster=="+name)
-	req.URL.RawQuery = q.Encode()
-	req.Header.Add("Authorization", "Bearer "+c.hCloudToken)
-	resp, err := c.httpClient.Do(req)
-	if err != nil {
-		var neterr net.Error
-		if errors.As(err, &neterr) && (neterr.Timeout() || neterr.Temporary()) {
-			return nil, &errorx.RetryableError{Message: "timeout or temporary error in HTTP request", Err: neterr}
+func (c *Client) GetFloatingIPsForCluster(ctx context.Context, name string) ([]*FloatingIP, error) {
+	var floatingIPs []*FloatingIP
+	query := url.Values{}
+	query.Set("label_selector", labelSelector(map[string]string{"cluster": name}))
+	if err := c.list(ctx, "/floating_ips", query, func(page json.RawMessage) error {
+		pageIPs, err := ParseFloatingIPs(page)
+		if err != nil {
+			return err
 		}
-		return nil, fmt.Errorf("error in http request: %w", err)
+		floatingIPs = append(floatingIPs, pageIPs...)
+		return nil
+	}); err != nil {
+		return nil, err
 	}
-	defer resp.Body.Close()
-	if resp.StatusCode != 200 {
-		return nil, fmt.Errorf("unexpected status code %d != 200", resp.StatusCode)
-	}
-	var rawFloatingIPs struct {
-		FloatingIPs []struct {
-			Type string `json:"type"`
-			IP   string `json:"ip"`
-		} `json:"floating_ips"`
-	}
-	decoder := json.NewDecoder(resp.Body)
-	err = decoder.Decode(&rawFloatingIPs)
-	if err != nil {
+	return floatingIPs, nil
+}
+
+// ParseFloatingIPs parses a (page of a) floating IP list response of the Hetzner API
+func ParseFloatingIPs(buf []byte) ([]*FloatingIP, error) {
+	var (
+		floatingIPs    []*FloatingIP
+		rawFloatingIPs struct {
+			FloatingIPs []struct {
+				Type string `json:"type"`
+				IP   string `json:"ip"`
+			} `json:"floating_ips"`
+		}
+	)
+	if err := json.Unmarshal(buf, &rawFloatingIPs); err != nil {
 		return nil, fmt.Errorf("error unmarshalling JSON: %w", err)
 	}
-	var floatingIPs []*FloatingIP
 	for _, rawIP := range rawFloatingIPs.FloatingIPs {
 		ip := &FloatingIP{IP: rawIP.IP}
 		switch rawIP.Type {
@@ -0,0 +1,91 @@
+package api
+
+import (
+	"net"
+	"testing"
+)
+
+// testNetwork returns a network with a subnet for every IP range
+func testNetwork(ranges ...string) *Network {
+	network := &Network{Name: "test", IPRange: "10.0.0.0/8", GatewayIP: "10.0.0.1"}
+	for _, r := range ranges {
+		network.Subnets = append(network.Subnets, &Subnet{Type: "cloud", IPRange: r, GatewayIP: "10.0.0.1"})
+	}
+	return network
+}
+
+func TestNetworkSubnetContaining(t *testing.T) {
+	tests := []struct {
+		name    string
+		subnets []string
+		ip      string
+		want    string
+		wantErr bool
+	}{
+		{name: "/16", subnets: []string{"10.1.0.0/16"}, ip: "10.1.200.3", want: "10.1.0.0/16"},
+		{name: "/20", subnets: []string{"10.2.16.0/20"}, ip: "10.2.31.254", want: "10.2.16.0/20"},
+		{name: "/24", subnets: []string{"10.3.4.0/24"}, ip: "10.3.4.2", want: "10.3.4.0/24"},
+		{name: "/28", subnets: []string{"10.4.0.16/28"}, ip: "10.4.0.20", want: "10.4.0.16/28"},
+		{name: "second subnet", subnets: []string{"10.5.0.0/24", "10.6.0.0/24"}, ip: "10.6.0.9", want: "10.6.0.0/24"},
+		{name: "overlapping, most specific wins", subnets: []string{"10.7.0.0/16", "10.7.4.0/24", "10.7.0.0/20"}, ip: "10.7.4.5", want: "10.7.4.0/24"},
+		{name: "overlapping, most specific listed first", subnets: []string{"10.8.1.0/28", "10.8.0.0/16"}, ip: "10.8.1.2", want: "10.8.1.0/28"},
+		{name: "overlapping, only the wider contains the IP", subnets: []string{"10.9.0.0/16", "10.9.4.0/24"}, ip: "10.9.5.1", want: "10.9.0.0/16"},
+		{name: "outside of /28", subnets: []string{"10.4.0.16/28"}, ip: "10.4.0.32", wantErr: true},
+		{name: "no subnets", ip: "10.0.0.2", wantErr: true},
+		{name: "invalid subnet", subnets: []string{"10.0.0.0/33"}, ip: "10.0.0.2", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := testNetwork(tt.subnets...).SubnetContaining(net.ParseIP(tt.ip))
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got %s", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got.String() != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNetworkAssociationIPv4Net(t *testing.T) {
+	tests := []struct {
+		name     string
+		subnets  []string
+		serverIP string
+		want     string
+		wantErr  bool
+	}{
+		{name: "/16", subnets: []string{"10.1.0.0/16"}, serverIP: "10.1.200.3", want: "10.1.200.3/16"},
+		{name: "/20", subnets: []string{"10.2.16.0/20"}, serverIP: "10.2.17.4", want: "10.2.17.4/20"},
+		{name: "/24", subnets: []string{"10.3.4.0/24"}, serverIP: "10.3.4.2", want: "10.3.4.2/24"},
+		{name: "/28", subnets: []string{"10.4.0.16/28"}, serverIP: "10.4.0.18", want: "10.4.0.18/28"},
+		{name: "overlapping, most specific wins", subnets: []string{"10.7.0.0/16", "10.7.4.0/24"}, serverIP: "10.7.4.5", want: "10.7.4.5/24"},
+		{name: "not in any subnet", subnets: []string{"10.3.4.0/24"}, serverIP: "10.3.5.2", wantErr: true},
+		{name: "IPv6 server IP", subnets: []string{"10.3.4.0/24"}, serverIP: "fd00::2", wantErr: true},
+		{name: "invalid server IP", subnets: []string{"10.3.4.0/24"}, serverIP: "10.3.4", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assoc := &NetworkAssociation{ID: "1", ServerIP: tt.serverIP}
+			got, err := assoc.IPv4Net(testNetwork(tt.subnets...))
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got %s", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got.String() != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
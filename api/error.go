@@ -0,0 +1,99 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// ErrorCode is the machine-readable error code of a failed Hetzner API request
+type ErrorCode string
+
+const (
+	// ErrorCodeUnauthorized means the token is invalid or missing
+	ErrorCodeUnauthorized ErrorCode = "unauthorized"
+
+	// ErrorCodeForbidden means the token lacks the permission for the request
+	ErrorCodeForbidden ErrorCode = "forbidden"
+
+	// ErrorCodeNotFound means the resource does not exist
+	ErrorCodeNotFound ErrorCode = "not_found"
+
+	// ErrorCodeInvalidInput means the request was malformed
+	ErrorCodeInvalidInput ErrorCode = "invalid_input"
+
+	// ErrorCodeLocked means the resource is locked by another action
+	ErrorCodeLocked ErrorCode = "locked"
+
+	// ErrorCodeConflict means the resource was changed during the request
+	ErrorCodeConflict ErrorCode = "conflict"
+
+	// ErrorCodeRateLimitExceeded means the project's rate limit is exhausted
+	ErrorCodeRateLimitExceeded ErrorCode = "rate_limit_exceeded"
+
+	// ErrorCodeServiceError means the API had an internal error
+	ErrorCodeServiceError ErrorCode = "service_error"
+
+	// ErrorCodeMaintenance means the API is in maintenance
+	ErrorCodeMaintenance ErrorCode = "maintenance"
+
+	// ErrorCodeTimeout means the API did not answer in time
+	ErrorCodeTimeout ErrorCode = "timeout"
+
+	// ErrorCodeUnavailable means the API is temporarily unavailable
+	ErrorCodeUnavailable ErrorCode = "unavailable"
+)
+
+// Error is a failed request to the Hetzner API or the server metadata service
+type Error struct {
+	StatusCode int
+	Code       ErrorCode
+	Message    string
+	RequestID  string
+
+	// RetryAfter is a hint how long to wait before retrying, zero means no hint
+	RetryAfter time.Duration
+}
+
+// Error is the error message
+func (e *Error) Error() string {
+	msg := fmt.Sprintf("unexpected status code %d != 200", e.StatusCode)
+	if len(e.Code) > 0 {
+		msg += fmt.Sprintf(": %s (%s)", e.Message, e.Code)
+	}
+	if len(e.RequestID) > 0 {
+		msg += fmt.Sprintf(" [request ID %s]", e.RequestID)
+	}
+	return msg
+}
+
+// Retryable returns true if the request may succeed when retried
+func (e *Error) Retryable() bool {
+	switch e.Code {
+	case ErrorCodeLocked, ErrorCodeConflict, ErrorCodeRateLimitExceeded, ErrorCodeServiceError, ErrorCodeMaintenance, ErrorCodeTimeout, ErrorCodeUnavailable:
+		return true
+	}
+	return e.StatusCode == http.StatusTooManyRequests || (e.StatusCode >= 500 && e.StatusCode < 600)
+}
+
+// Temporary implements errorx.Temporary
+func (e *Error) Temporary() bool {
+	return e.Retryable()
+}
+
+// IsErrorCode returns true if err is or wraps an Error with the given code
+func IsErrorCode(err error, code ErrorCode) bool {
+	var apiErr *Error
+	return errors.As(err, &apiErr) && apiErr.Code == code
+}
+
+// IsAuthError returns true if err is or wraps an Error caused by an invalid token or missing permissions
+func IsAuthError(err error) bool {
+	var apiErr *Error
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	return apiErr.Code == ErrorCodeUnauthorized || apiErr.Code == ErrorCodeForbidden ||
+		apiErr.StatusCode == http.StatusUnauthorized || apiErr.StatusCode == http.StatusForbidden
+}
@@ -0,0 +1,124 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Metadata is the server's own configuration as reported by the server metadata service
+type Metadata struct {
+	InstanceID      string
+	Hostname        string
+	PublicIPv4      string
+	IPv6Subnet      string
+	PrivateNetworks []*MetadataPrivateNetwork
+}
+
+// MetadataPrivateNetwork is a private network attachment as reported by the server metadata service
+type MetadataPrivateNetwork struct {
+	NetworkID    string
+	NetworkName  string
+	ServerIP     string
+	MACAddress   string
+	InterfaceNum int
+	Network      string
+	Subnet       string
+	Gateway      string
+}
+
+// GetPrivateNetworks retrieves the server's private network attachments from the server metadata service
+func (c *Client) GetPrivateNetworks(ctx context.Context) ([]*MetadataPrivateNetwork, error) {
+	var (
+		buf             string
+		rawNetworks     []rawMetadataPrivateNetwork
+		privateNetworks []*MetadataPrivateNetwork
+		err             error
+	)
+	if buf, err = c.getMetadata(ctx, "/hetzner/v1/metadata/private-networks"); err != nil {
+		return nil, err
+	}
+	if err = yaml.Unmarshal([]byte(buf), &rawNetworks); err != nil {
+		return nil, fmt.Errorf("error unmarshalling YAML: %w", err)
+	}
+	for _, raw := range rawNetworks {
+		privateNetworks = append(privateNetworks, &MetadataPrivateNetwork{
+			NetworkID:    raw.NetworkID,
+			NetworkName:  raw.NetworkName,
+			ServerIP:     raw.IP,
+			MACAddress:   raw.MACAddress,
+			InterfaceNum: raw.InterfaceNum,
+			Network:      raw.Network,
+			Subnet:       raw.Subnet,
+			Gateway:      raw.Gateway,
+		})
+	}
+	return privateNetworks, nil
+}
+
+type rawMetadataPrivateNetwork struct {
+	IP           string `yaml:"ip"`
+	MACAddress   string `yaml:"mac_address"`
+	InterfaceNum int    `yaml:"interface_num"`
+	NetworkID    string `yaml:"network_id"`
+	NetworkName  string `yaml:"network_name"`
+	Network      string `yaml:"network"`
+	Subnet       string `yaml:"subnet"`
+	Gateway      string `yaml:"gateway"`
+}
+
+// GetMetadata retrieves everything the node needs to configure its own networking from the server metadata service
+func (c *Client) GetMetadata(ctx context.Context) (*Metadata, error) {
+	var (
+		buf string
+		raw struct {
+			InstanceID    string `yaml:"instance-id"`
+			Hostname      string `yaml:"hostname"`
+			PublicIPv4    string `yaml:"public-ipv4"`
+			NetworkConfig struct {
+				Config []struct {
+					Name    string `yaml:"name"`
+					Subnets []struct {
+						Type    string `yaml:"type"`
+						IPv6    bool   `yaml:"ipv6"`
+						Address string `yaml:"address"`
+					} `yaml:"subnets"`
+				} `yaml:"config"`
+			} `yaml:"network-config"`
+		}
+		md  Metadata
+		err error
+	)
+	if buf, err = c.getMetadata(ctx, "/hetzner/v1/metadata"); err != nil {
+		return nil, err
+	}
+	if err = yaml.Unmarshal([]byte(buf), &raw); err != nil {
+		return nil, fmt.Errorf("error unmarshalling YAML: %w", err)
+	}
+	md.InstanceID = raw.InstanceID
+	md.Hostname = raw.Hostname
+	md.PublicIPv4 = raw.PublicIPv4
+	for _, iface := range raw.NetworkConfig.Config {
+		for _, subnet := range iface.Subnets {
+			if subnet.IPv6 && subnet.Type == "static" && len(md.IPv6Subnet) == 0 {
+				md.IPv6Subnet = subnet.Address
+			}
+		}
+	}
+	if len(md.InstanceID) == 0 {
+		return nil, fmt.Errorf("invalid: got empty instance-id in metadata")
+	}
+	if len(md.PublicIPv4) == 0 {
+		return nil, fmt.Errorf("invalid: got empty public-ipv4 in metadata")
+	}
+	if len(md.IPv6Subnet) == 0 {
+		return nil, fmt.Errorf("invalid: got no static IPv6 subnet in metadata network-config")
+	}
+	if md.PrivateNetworks, err = c.GetPrivateNetworks(ctx); err != nil {
+		return nil, fmt.Errorf("error getting private networks: %w", err)
+	}
+	md.Hostname = strings.TrimSpace(md.Hostname)
+	return &md, nil
+}
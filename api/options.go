@@ -0,0 +1,155 @@
+package api
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// DefaultMetadataBaseURL is the base URL of the server metadata service
+const DefaultMetadataBaseURL = "http://169.254.169.254"
+
+// DefaultAPIBaseURL is the base URL of the Hetzner Cloud API
+const DefaultAPIBaseURL = "https://api.hetzner.cloud/v1"
+
+// DefaultTimeout is the timeout of a single HTTP request
+const DefaultTimeout = 3 * time.Second
+
+// Option configures a Client
+type Option func(*options) error
+
+type options struct {
+	metadataBaseURL string
+	apiBaseURL      string
+	httpClient      *http.Client
+	transport       http.RoundTripper
+	timeout         time.Duration
+	caCerts         [][]byte
+}
+
+// WithMetadataBaseURL sets the base URL of the server metadata service
+func WithMetadataBaseURL(url string) Option {
+	return func(o *options) error {
+		if len(url) == 0 {
+			return fmt.Errorf("invalid: got empty metadata base URL")
+		}
+		o.metadataBaseURL = strings.TrimSuffix(url, "/")
+		return nil
+	}
+}
+
+// WithAPIBaseURL sets the base URL of the Hetzner Cloud API
+func WithAPIBaseURL(url string) Option {
+	return func(o *options) error {
+		if len(url) == 0 {
+			return fmt.Errorf("invalid: got empty API base URL")
+		}
+		o.apiBaseURL = strings.TrimSuffix(url, "/")
+		return nil
+	}
+}
+
+// WithHTTPClient sets the HTTP client which performs the requests
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(o *options) error {
+		o.httpClient = httpClient
+		return nil
+	}
+}
+
+// WithTransport sets the transport of the HTTP client
+func WithTransport(transport http.RoundTripper) Option {
+	return func(o *options) error {
+		o.transport = transport
+		return nil
+	}
+}
+
+// WithTimeout sets the timeout of a single HTTP request
+func WithTimeout(timeout time.Duration) Option {
+	return func(o *options) error {
+		o.timeout = timeout
+		return nil
+	}
+}
+
+// WithCACerts adds PEM encoded CA certificates to the system's trusted certificates
+func WithCACerts(pem ...[]byte) Option {
+	return func(o *options) error {
+		o.caCerts = append(o.caCerts, pem...)
+		return nil
+	}
+}
+
+// WithCACertFiles reads PEM encoded CA certificates from files and adds them to the system's trusted certificates
+func WithCACertFiles(paths ...string) Option {
+	return func(o *options) error {
+		for _, path := range paths {
+			buf, err := ioutil.ReadFile(path)
+			if err != nil {
+				return fmt.Errorf("error reading CA certificate at \"%s\": %w", path, err)
+			}
+			o.caCerts = append(o.caCerts, buf)
+		}
+		return nil
+	}
+}
+
+// NewHTTPClient returns the HTTP client configured by the transport, timeout and CA certificates options, e.g. for downloads which trust the same CAs as the Client
+func NewHTTPClient(opts ...Option) (*http.Client, error) {
+	o := &options{}
+	for _, opt := range opts {
+		if err := opt(o); err != nil {
+			return nil, fmt.Errorf("error applying client option: %w", err)
+		}
+	}
+	return httpClientFromOptions(o)
+}
+
+// httpClientFromOptions builds the HTTP client from the transport, timeout and CA certificates options, DefaultTimeout applies if neither the options nor the given client set a timeout
+func httpClientFromOptions(o *options) (*http.Client, error) {
+	httpClient := &http.Client{}
+	if o.httpClient != nil {
+		*httpClient = *o.httpClient
+	}
+	if o.transport != nil {
+		httpClient.Transport = o.transport
+	}
+	if o.timeout > 0 {
+		httpClient.Timeout = o.timeout
+	} else if httpClient.Timeout == 0 {
+		httpClient.Timeout = DefaultTimeout
+	}
+	if len(o.caCerts) == 0 {
+		return httpClient, nil
+	}
+
+	pool, err := x509.SystemCertPool()
+	if err != nil || pool == nil {
+		pool = x509.NewCertPool()
+	}
+	for _, pem := range o.caCerts {
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("invalid: no certificates found in CA certificate PEM")
+		}
+	}
+	var transport *http.Transport
+	switch t := httpClient.Transport.(type) {
+	case nil:
+		transport = http.DefaultTransport.(*http.Transport).Clone()
+	case *http.Transport:
+		transport = t.Clone()
+	default:
+		return nil, fmt.Errorf("CA certificates require an *http.Transport, got %T", t)
+	}
+	if transport.TLSClientConfig == nil {
+		transport.TLSClientConfig = &tls.Config{}
+	}
+	transport.TLSClientConfig.RootCAs = pool
+	httpClient.Transport = transport
+	return httpClient, nil
+}
@@ -0,0 +1,233 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shark/hcloud-k3os-configurator/errorx"
+)
+
+// perPage is the page size requested from paginated endpoints (the maximum allowed by the API)
+const perPage = 50
+
+// minRetryAfter and maxRetryAfter bound the wait hint of rate limited requests
+const (
+	minRetryAfter = 1 * time.Second
+	maxRetryAfter = 1 * time.Minute
+)
+
+// RateLimit is the rate limit state as reported by a response of the Hetzner API
+type RateLimit struct {
+	Limit     int
+	Remaining int
+	Reset     time.Time
+}
+
+// pagination is the meta.pagination block of list responses
+type pagination struct {
+	Page         int  `json:"page"`
+	PerPage      int  `json:"per_page"`
+	PreviousPage *int `json:"previous_page"`
+	NextPage     *int `json:"next_page"`
+	LastPage     *int `json:"last_page"`
+	TotalEntries *int `json:"total_entries"`
+}
+
+// errorResponse is the body of a failed request to the Hetzner API
+type errorResponse struct {
+	Error struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// labelSelector builds a label selector which matches all of the given labels
+func labelSelector(labels map[string]string) string {
+	var (
+		keys      []string
+		selectors []string
+	)
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		selectors = append(selectors, fmt.Sprintf("%s==%s", k, labels[k]))
+	}
+	return strings.Join(selectors, ",")
+}
+
+// send performs a request and returns the response if the status code is 200, the caller must close the body
+func (c *Client) send(req *http.Request) (*http.Response, error) {
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		if ctxErr := req.Context().Err(); ctxErr != nil {
+			return nil, fmt.Errorf("error in http request: %w", ctxErr)
+		}
+		var neterr net.Error
+		if errors.As(err, &neterr) && (neterr.Timeout() || neterr.Temporary()) {
+			return nil, &errorx.RetryableError{Message: "timeout or temporary error in HTTP request", Err: neterr}
+		}
+		return nil, fmt.Errorf("error in http request: %w", err)
+	}
+	if resp.StatusCode != 200 {
+		defer resp.Body.Close()
+		return nil, statusError(resp)
+	}
+	return resp, nil
+}
+
+// statusError builds the error for a response with an unexpected status code
+func statusError(resp *http.Response) error {
+	var (
+		body    []byte
+		errResp errorResponse
+		apiErr  = &Error{StatusCode: resp.StatusCode}
+	)
+	if body, _ = ioutil.ReadAll(resp.Body); len(body) > 0 {
+		if json.Unmarshal(body, &errResp) == nil {
+			apiErr.Code = ErrorCode(errResp.Error.Code)
+			apiErr.Message = errResp.Error.Message
+		}
+	}
+	if apiErr.RequestID = resp.Header.Get("X-Correlation-Id"); len(apiErr.RequestID) == 0 {
+		apiErr.RequestID = resp.Header.Get("X-Request-Id")
+	}
+	if resp.StatusCode == http.StatusTooManyRequests || apiErr.Code == ErrorCodeRateLimitExceeded {
+		apiErr.RetryAfter = retryAfter(resp.Header)
+	}
+	if apiErr.Retryable() {
+		return &errorx.RetryableError{Message: "retryable HTTP error", Err: apiErr, RetryAfter: apiErr.RetryAfter}
+	}
+	return apiErr
+}
+
+// parseRateLimit reads the rate limit headers of a response
+func parseRateLimit(header http.Header) (*RateLimit, bool) {
+	var (
+		limit, remaining, reset int
+		err                     error
+	)
+	if limit, err = strconv.Atoi(header.Get("RateLimit-Limit")); err != nil {
+		return nil, false
+	}
+	if remaining, err = strconv.Atoi(header.Get("RateLimit-Remaining")); err != nil {
+		return nil, false
+	}
+	if reset, err = strconv.Atoi(header.Get("RateLimit-Reset")); err != nil {
+		return nil, false
+	}
+	return &RateLimit{
+		Limit:     limit,
+		Remaining: remaining,
+		Reset:     time.Unix(int64(reset), 0),
+	}, true
+}
+
+// Wait returns the time until the next request is expected to be allowed, assuming the limit refills linearly until Reset
+func (r *RateLimit) Wait(now time.Time) time.Duration {
+	if r.Remaining > 0 || r.Limit <= 0 || !r.Reset.After(now) {
+		return 0
+	}
+	return r.Reset.Sub(now) / time.Duration(r.Limit)
+}
+
+// retryAfter computes how long to wait before retrying a rate limited request
+func retryAfter(header http.Header) time.Duration {
+	var wait time.Duration
+	if secs, err := strconv.Atoi(header.Get("Retry-After")); err == nil {
+		wait = time.Duration(secs) * time.Second
+	} else if rl, ok := parseRateLimit(header); ok {
+		wait = rl.Wait(time.Now())
+	}
+	if wait < minRetryAfter {
+		return minRetryAfter
+	}
+	if wait > maxRetryAfter {
+		return maxRetryAfter
+	}
+	return wait
+}
+
+// getMetadata fetches a plain text value from the server metadata service
+func (c *Client) getMetadata(ctx context.Context, path string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", c.metadataBaseURL+path, nil)
+	if err != nil {
+		return "", fmt.Errorf("error creating request: %w", err)
+	}
+	resp, err := c.send(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	buf, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("error reading response body: %w", err)
+	}
+	return string(buf), nil
+}
+
+// get performs an authenticated GET request against the Hetzner API and decodes the JSON response into v
+func (c *Client) get(ctx context.Context, path string, query url.Values, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", c.apiBaseURL+path, nil)
+	if err != nil {
+		return fmt.Errorf("error creating request: %w", err)
+	}
+	if query != nil {
+		req.URL.RawQuery = query.Encode()
+	}
+	req.Header.Add("Authorization", "Bearer "+c.hCloudToken)
+	resp, err := c.send(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err = json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("error unmarshalling JSON: %w", err)
+	}
+	return nil
+}
+
+// list performs authenticated GET requests against a paginated endpoint and calls fn with the raw JSON of every page
+func (c *Client) list(ctx context.Context, path string, query url.Values, fn func(page json.RawMessage) error) error {
+	page := 1
+	for {
+		q := url.Values{}
+		for k, v := range query {
+			q[k] = v
+		}
+		q.Set("page", strconv.Itoa(page))
+		q.Set("per_page", strconv.Itoa(perPage))
+
+		var raw json.RawMessage
+		if err := c.get(ctx, path, q, &raw); err != nil {
+			return err
+		}
+		var rawMeta struct {
+			Meta struct {
+				Pagination *pagination `json:"pagination"`
+			} `json:"meta"`
+		}
+		if err := json.Unmarshal(raw, &rawMeta); err != nil {
+			return fmt.Errorf("error unmarshalling JSON: %w", err)
+		}
+		if err := fn(raw); err != nil {
+			return err
+		}
+		p := rawMeta.Meta.Pagination
+		if p == nil || p.NextPage == nil || *p.NextPage <= page {
+			return nil
+		}
+		page = *p.NextPage
+	}
+}
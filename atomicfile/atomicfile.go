@@ -0,0 +1,112 @@
+package atomicfile
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// DefaultMode is the mode of written files unless WithMode is given
+const DefaultMode os.FileMode = 0644
+
+type options struct {
+	mode os.FileMode
+	uid  int
+	gid  int
+}
+
+// Option configures Write
+type Option func(*options)
+
+// WithMode sets the permissions of the file
+func WithMode(mode os.FileMode) Option {
+	return func(o *options) {
+		o.mode = mode
+	}
+}
+
+// WithOwner sets the owner and group of the file, by default they are the ones of the current process
+func WithOwner(uid, gid int) Option {
+	return func(o *options) {
+		o.uid = uid
+		o.gid = gid
+	}
+}
+
+// Write replaces the file at path with data, readers either see the old or the new content but never a partially written file.
+// The file is not touched if its content, mode and owner are already as desired.
+func Write(path string, data []byte, opts ...Option) (err error) {
+	o := &options{mode: DefaultMode, uid: -1, gid: -1}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	if unchanged(path, data, o) {
+		return nil
+	}
+
+	dir := filepath.Dir(path)
+	var f *os.File
+	if f, err = ioutil.TempFile(dir, "."+filepath.Base(path)+".*.tmp"); err != nil {
+		return fmt.Errorf("error creating temp file for \"%s\": %w", path, err)
+	}
+	defer func() {
+		if err != nil {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}()
+
+	if _, err = f.Write(data); err != nil {
+		return fmt.Errorf("error writing temp file for \"%s\": %w", path, err)
+	}
+	if err = f.Chmod(o.mode); err != nil {
+		return fmt.Errorf("error setting mode of \"%s\": %w", path, err)
+	}
+	if o.uid >= 0 || o.gid >= 0 {
+		if err = f.Chown(o.uid, o.gid); err != nil {
+			return fmt.Errorf("error setting owner of \"%s\": %w", path, err)
+		}
+	}
+	if err = f.Sync(); err != nil {
+		return fmt.Errorf("error syncing temp file for \"%s\": %w", path, err)
+	}
+	if err = f.Close(); err != nil {
+		return fmt.Errorf("error closing temp file for \"%s\": %w", path, err)
+	}
+	if err = os.Rename(f.Name(), path); err != nil {
+		return fmt.Errorf("error renaming temp file to \"%s\": %w", path, err)
+	}
+	return syncDir(dir)
+}
+
+// unchanged is true if the file at path already has content data and the desired mode and owner
+func unchanged(path string, data []byte, o *options) bool {
+	fi, err := os.Stat(path)
+	if err != nil || !fi.Mode().IsRegular() || fi.Mode().Perm() != o.mode.Perm() {
+		return false
+	}
+	if o.uid >= 0 || o.gid >= 0 {
+		uid, gid, ok := fileOwner(fi)
+		if !ok || (o.uid >= 0 && uid != o.uid) || (o.gid >= 0 && gid != o.gid) {
+			return false
+		}
+	}
+	current, err := ioutil.ReadFile(path)
+	return err == nil && bytes.Equal(current, data)
+}
+
+// syncDir makes the rename in dir durable
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("error opening directory \"%s\": %w", dir, err)
+	}
+	defer d.Close()
+	if err = d.Sync(); err != nil {
+		return fmt.Errorf("error syncing directory \"%s\": %w", dir, err)
+	}
+	return nil
+}
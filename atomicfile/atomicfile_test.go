@@ -0,0 +1,156 @@
+package atomicfile
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "hcloud-k3os-atomicfile-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+// assertFile fails unless the file at p has content data and mode
+func assertFile(t *testing.T, p string, data string, mode os.FileMode) os.FileInfo {
+	t.Helper()
+	fi, err := os.Stat(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != mode {
+		t.Errorf("got mode %s, want %s", fi.Mode().Perm(), mode)
+	}
+	buf, err := ioutil.ReadFile(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != data {
+		t.Errorf("got content '%s', want '%s'", buf, data)
+	}
+	return fi
+}
+
+// assertNoTempFiles fails if a temp file was left behind in dir
+func assertNoTempFiles(t *testing.T, dir string) {
+	t.Helper()
+	matches, err := filepath.Glob(filepath.Join(dir, ".*.tmp"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(matches) > 0 {
+		t.Errorf("temp files were left behind: %v", matches)
+	}
+}
+
+func TestWrite(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	p := filepath.Join(dir, "file")
+
+	if err := Write(p, []byte("one")); err != nil {
+		t.Fatal(err)
+	}
+	first := assertFile(t, p, "one", DefaultMode)
+
+	// unchanged content and mode are not written again
+	if err := Write(p, []byte("one")); err != nil {
+		t.Fatal(err)
+	}
+	if fi := assertFile(t, p, "one", DefaultMode); !os.SameFile(first, fi) {
+		t.Error("unchanged file was replaced")
+	}
+
+	// a changed mode or content replaces the file
+	if err := Write(p, []byte("one"), WithMode(0600)); err != nil {
+		t.Fatal(err)
+	}
+	second := assertFile(t, p, "one", 0600)
+	if os.SameFile(first, second) {
+		t.Error("file with changed mode was not replaced")
+	}
+	if err := Write(p, []byte("two"), WithMode(0600)); err != nil {
+		t.Fatal(err)
+	}
+	if fi := assertFile(t, p, "two", 0600); os.SameFile(second, fi) {
+		t.Error("file with changed content was not replaced")
+	}
+	assertNoTempFiles(t, dir)
+}
+
+func TestWriteOwner(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("file owners are unknown on Windows")
+	}
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	var (
+		p        = filepath.Join(dir, "file")
+		uid, gid = os.Getuid(), os.Getgid()
+	)
+
+	if err := Write(p, []byte("secret"), WithMode(0600), WithOwner(uid, gid)); err != nil {
+		t.Fatal(err)
+	}
+	first := assertFile(t, p, "secret", 0600)
+	if gotUID, gotGID, ok := fileOwner(first); !ok || gotUID != uid || gotGID != gid {
+		t.Errorf("got owner %d:%d, want %d:%d", gotUID, gotGID, uid, gid)
+	}
+
+	// the owner is part of the unchanged check
+	if err := Write(p, []byte("secret"), WithMode(0600), WithOwner(uid, gid)); err != nil {
+		t.Fatal(err)
+	}
+	if fi := assertFile(t, p, "secret", 0600); !os.SameFile(first, fi) {
+		t.Error("unchanged file was replaced")
+	}
+
+	// a changed owner replaces the file, only root may give it to another user
+	err := Write(p, []byte("secret"), WithMode(0600), WithOwner(uid+1, -1))
+	if uid != 0 {
+		if err == nil {
+			t.Error("expected an error giving the file to another user")
+		}
+		if fi := assertFile(t, p, "secret", 0600); !os.SameFile(first, fi) {
+			t.Error("file was replaced although the write failed")
+		}
+	} else {
+		if err != nil {
+			t.Fatal(err)
+		}
+		fi := assertFile(t, p, "secret", 0600)
+		if gotUID, _, _ := fileOwner(fi); gotUID != uid+1 || os.SameFile(first, fi) {
+			t.Errorf("file was not replaced and given to %d, got owner %d", uid+1, gotUID)
+		}
+	}
+	assertNoTempFiles(t, dir)
+}
+
+func TestWriteFailure(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	// renaming the temp file over a directory fails
+	p := filepath.Join(dir, "dir")
+	if err := os.MkdirAll(filepath.Join(p, "child"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := Write(p, []byte("data")); err == nil {
+		t.Fatal("expected an error")
+	}
+	if fi, err := os.Stat(p); err != nil || !fi.IsDir() {
+		t.Errorf("directory was replaced: %v", err)
+	}
+	assertNoTempFiles(t, dir)
+
+	// the temp file can't be created in a missing directory
+	if err := Write(filepath.Join(dir, "missing", "file"), []byte("data")); err == nil {
+		t.Fatal("expected an error")
+	}
+	assertNoTempFiles(t, dir)
+}
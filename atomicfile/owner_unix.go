@@ -0,0 +1,18 @@
+//go:build !windows
+// +build !windows
+
+package atomicfile
+
+import (
+	"os"
+	"syscall"
+)
+
+// fileOwner returns the owner and group of a file
+func fileOwner(fi os.FileInfo) (uid, gid int, ok bool) {
+	stat, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0, false
+	}
+	return int(stat.Uid), int(stat.Gid), true
+}
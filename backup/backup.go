@@ -1,6 +1,7 @@
 package backup
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"os"
@@ -9,6 +10,7 @@ import (
 	"github.com/sirupsen/logrus"
 
 	"github.com/shark/hcloud-k3os-configurator/cmd"
+	"github.com/shark/hcloud-k3os-configurator/errorx"
 	"github.com/shark/hcloud-k3os-configurator/model"
 )
 
@@ -16,6 +18,9 @@ const bootstrappedFile = "/var/lib/rancher/.bootstrapped"
 const backupDir = "/var/lib/rancher"
 const cacheDir = "/var/lib/hcloud-k3os/cache"
 
+// resticExitLocked is restic's exit code when the repository is locked by another process
+const resticExitLocked = 11
+
 // IsBootstrapped returns true if the node is bootstrapped
 func IsBootstrapped() bool {
 	var err error
@@ -26,7 +31,7 @@ func IsBootstrapped() bool {
 }
 
 // MarkBootstrapped creates the bootstrap file
-func MarkBootstrapped(log *logrus.Logger, dry bool) error {
+func MarkBootstrapped(ctx context.Context, log *logrus.Logger, dry bool) error {
 	var (
 		tcmd = &cmd.Command{
 			Name: "touch",
@@ -34,14 +39,14 @@ func MarkBootstrapped(log *logrus.Logger, dry bool) error {
 		}
 		err error
 	)
-	if _, err = cmd.Run(tcmd, log, dry); err != nil {
+	if _, err = cmd.Run(ctx, tcmd, log, dry); err != nil {
 		return fmt.Errorf("error touching bootstrappedFile: %v", err)
 	}
 	return nil
 }
 
 // Init initializes the restic repository
-func Init(bcfg *model.BackupConfig, log *logrus.Logger, dry bool) error {
+func Init(ctx context.Context, bcfg *model.BackupConfig, log *logrus.Logger, dry bool) error {
 	var (
 		icmd = &cmd.Command{
 			Name: "restic",
@@ -50,17 +55,21 @@ func Init(bcfg *model.BackupConfig, log *logrus.Logger, dry bool) error {
 				"--cache-dir",
 				cacheDir,
 			},
-			Env: resticEnv(bcfg),
+			Env:                resticEnv(bcfg),
+			TemporaryExitCodes: []int{resticExitLocked},
 		}
 		out string
 		err error
 	)
 
-	if out, err = cmd.Run(icmd, log, dry); err != nil {
+	if err = createCacheDir(dry); err != nil {
+		return err
+	}
+	if out, err = cmd.Run(ctx, icmd, log, dry); err != nil {
 		if strings.Contains(out, "already initialized") {
 			return nil
 		}
-		return fmt.Errorf("error initializing restic repository: %v", err)
+		return resticError("error initializing restic repository", out, err)
 	}
 
 	return nil
@@ -79,7 +88,7 @@ type Snapshot struct {
 }
 
 // ListSnapshots lists the snapshots in a restic repository
-func ListSnapshots(bcfg *model.BackupConfig, log *logrus.Logger, dry bool) ([]*Snapshot, error) {
+func ListSnapshots(ctx context.Context, bcfg *model.BackupConfig, log *logrus.Logger, dry bool) ([]*Snapshot, error) {
 	var (
 		lcmd = &cmd.Command{
 			Name: "restic",
@@ -87,15 +96,16 @@ func ListSnapshots(bcfg *model.BackupConfig, log *logrus.Logger, dry bool) ([]*S
 				"--json",
 				"snapshots",
 			},
-			Env: resticEnv(bcfg),
+			Env:                resticEnv(bcfg),
+			TemporaryExitCodes: []int{resticExitLocked},
 		}
 		out       string
 		snapshots []*Snapshot
 		err       error
 	)
 
-	if out, err = cmd.Run(lcmd, log, dry); err != nil {
-		return nil, fmt.Errorf("error running list command: %v", err)
+	if out, err = cmd.Run(ctx, lcmd, log, dry); err != nil {
+		return nil, resticError("error running list command", out, err)
 	}
 
 	if err = json.Unmarshal([]byte(out), &snapshots); err != nil {
@@ -106,7 +116,7 @@ func ListSnapshots(bcfg *model.BackupConfig, log *logrus.Logger, dry bool) ([]*S
 }
 
 // Restore restores the latest restic backup
-func Restore(bcfg *model.BackupConfig, log *logrus.Logger, dry bool) error {
+func Restore(ctx context.Context, bcfg *model.BackupConfig, log *logrus.Logger, dry bool) error {
 	var (
 		rcmd = &cmd.Command{
 			Name: "restic",
@@ -120,20 +130,25 @@ func Restore(bcfg *model.BackupConfig, log *logrus.Logger, dry bool) error {
 				"--path",
 				backupDir,
 			},
-			Env: resticEnv(bcfg),
+			Env:                resticEnv(bcfg),
+			TemporaryExitCodes: []int{resticExitLocked},
 		}
+		out string
 		err error
 	)
 
-	if _, err = cmd.Run(rcmd, log, dry); err != nil {
-		return fmt.Errorf("error running restore command: %v", err)
+	if err = createCacheDir(dry); err != nil {
+		return err
+	}
+	if out, err = cmd.Run(ctx, rcmd, log, dry); err != nil {
+		return resticError("error running restore command", out, err)
 	}
 
 	return nil
 }
 
 // Backup runs a restic backup
-func Backup(bcfg *model.BackupConfig, log *logrus.Logger, dry bool) error {
+func Backup(ctx context.Context, bcfg *model.BackupConfig, log *logrus.Logger, dry bool) error {
 	var (
 		bcmd = &cmd.Command{
 			Name: "restic",
@@ -147,18 +162,43 @@ func Backup(bcfg *model.BackupConfig, log *logrus.Logger, dry bool) error {
 				"/var/lib/rancher/k3s/data",
 				backupDir,
 			},
-			Env: resticEnv(bcfg),
+			Env:                resticEnv(bcfg),
+			TemporaryExitCodes: []int{resticExitLocked},
 		}
+		out string
 		err error
 	)
 
-	if _, err = cmd.Run(bcmd, log, dry); err != nil {
-		return fmt.Errorf("error running backup command: %v", err)
+	if err = createCacheDir(dry); err != nil {
+		return err
+	}
+	if out, err = cmd.Run(ctx, bcmd, log, dry); err != nil {
+		return resticError("error running backup command", out, err)
 	}
 
 	return nil
 }
 
+// createCacheDir creates the restic cache directory unless dry is set
+func createCacheDir(dry bool) error {
+	if dry {
+		return nil
+	}
+	if err := os.MkdirAll(cacheDir, 0755); err != nil {
+		return fmt.Errorf("error creating %s: %w", cacheDir, err)
+	}
+	return nil
+}
+
+// resticError wraps the error of a restic run, it is retryable if the repository was locked by another process
+func resticError(msg string, out string, err error) error {
+	err = fmt.Errorf("%s: %w", msg, err)
+	if strings.Contains(out, "repository is already locked") {
+		return &errorx.RetryableError{Message: "restic repository is locked", Err: err}
+	}
+	return err
+}
+
 func resticEnv(bcfg *model.BackupConfig) map[string]string {
 	return map[string]string{
 		"RESTIC_PASSWORD":       bcfg.Password,
@@ -0,0 +1,36 @@
+package cli
+
+import (
+	"net/http"
+
+	"github.com/shark/hcloud-k3os-configurator/api"
+	"github.com/shark/hcloud-k3os-configurator/download"
+	"github.com/shark/hcloud-k3os-configurator/model"
+	"github.com/shark/hcloud-k3os-configurator/store/fetch"
+)
+
+// fetchMode returns the fetch mode set by the root command's flags
+func fetchMode(rcfg *model.RuntimeConfig) fetch.Mode {
+	if rcfg.MetadataFirst {
+		return fetch.ModeMetadataFirst
+	}
+	return fetch.ModeAPI
+}
+
+// apiOptions returns the API client options set by the root command's flags
+func apiOptions(rcfg *model.RuntimeConfig) []api.Option {
+	opts := []api.Option{
+		api.WithMetadataBaseURL(rcfg.MetadataBaseURL),
+		api.WithAPIBaseURL(rcfg.APIBaseURL),
+		api.WithTimeout(rcfg.APITimeout),
+	}
+	if len(rcfg.CACertFiles) > 0 {
+		opts = append(opts, api.WithCACertFiles(rcfg.CACertFiles...))
+	}
+	return opts
+}
+
+// downloadClient returns the HTTP client for downloads, it trusts the same CA certificates as the API client but has a longer timeout
+func downloadClient(rcfg *model.RuntimeConfig) (*http.Client, error) {
+	return api.NewHTTPClient(append(apiOptions(rcfg), api.WithTimeout(download.Timeout))...)
+}
@@ -31,17 +31,20 @@ func backupList(rcfg *model.RuntimeConfig) *cobra.Command {
 		Use:   "list",
 		Short: "List snapshots",
 		RunE: func(_ *cobra.Command, _ []string) error {
+			ctx, cancel := signalContext()
+			defer cancel()
+
 			var (
 				cfg       *model.HCloudK3OSConfig
 				snapshots []*backup.Snapshot
 				err       error
 			)
 
-			if cfg, err = store.LoadAndCache(); err != nil {
+			if cfg, err = store.LoadAndCache(ctx, rcfg.Logger, fetchMode(rcfg), apiOptions(rcfg)...); err != nil {
 				return fmt.Errorf("error loading config: %v", err)
 			}
 
-			if snapshots, err = backup.ListSnapshots(cfg.ClusterConfig.BackupConfig, rcfg.Logger, false); err != nil {
+			if snapshots, err = backup.ListSnapshots(ctx, cfg.ClusterConfig.BackupConfig, rcfg.Logger, false); err != nil {
 				return fmt.Errorf("error listing snapshots: %v", err)
 			}
 
@@ -63,16 +66,19 @@ func backupRun(rcfg *model.RuntimeConfig) *cobra.Command {
 		Use:   "run",
 		Short: "Run backup",
 		RunE: func(_ *cobra.Command, _ []string) error {
+			ctx, cancel := signalContext()
+			defer cancel()
+
 			var (
 				cfg *model.HCloudK3OSConfig
 				err error
 			)
 
-			if cfg, err = store.LoadAndCache(); err != nil {
+			if cfg, err = store.LoadAndCache(ctx, rcfg.Logger, fetchMode(rcfg), apiOptions(rcfg)...); err != nil {
 				return fmt.Errorf("error loading config: %v", err)
 			}
 
-			if err = backup.Backup(cfg.ClusterConfig.BackupConfig, rcfg.Logger, false); err != nil {
+			if err = backup.Backup(ctx, cfg.ClusterConfig.BackupConfig, rcfg.Logger, false); err != nil {
 				return fmt.Errorf("error running backup: %v", err)
 			}
 
@@ -87,16 +93,19 @@ func backupRestore(rcfg *model.RuntimeConfig) *cobra.Command {
 		Use:   "restore",
 		Short: "Restore backup",
 		RunE: func(_ *cobra.Command, _ []string) error {
+			ctx, cancel := signalContext()
+			defer cancel()
+
 			var (
 				cfg *model.HCloudK3OSConfig
 				err error
 			)
 
-			if cfg, err = store.LoadAndCache(); err != nil {
+			if cfg, err = store.LoadAndCache(ctx, rcfg.Logger, fetchMode(rcfg), apiOptions(rcfg)...); err != nil {
 				return fmt.Errorf("error loading config: %v", err)
 			}
 
-			if err = backup.Restore(cfg.ClusterConfig.BackupConfig, rcfg.Logger, false); err != nil {
+			if err = backup.Restore(ctx, cfg.ClusterConfig.BackupConfig, rcfg.Logger, false); err != nil {
 				return fmt.Errorf("error restoring backup: %v", err)
 			}
 
@@ -0,0 +1,328 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/olekukonko/tablewriter"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v2"
+
+	"github.com/shark/hcloud-k3os-configurator/addon"
+	"github.com/shark/hcloud-k3os-configurator/api"
+	"github.com/shark/hcloud-k3os-configurator/generator"
+	"github.com/shark/hcloud-k3os-configurator/model"
+	"github.com/shark/hcloud-k3os-configurator/store"
+	"github.com/shark/hcloud-k3os-configurator/store/fetch"
+)
+
+// Config implements the config commands
+func Config(rcfg *model.RuntimeConfig) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "config",
+		Short: "Inspect, validate and render the node config",
+	}
+
+	cmd.AddCommand(configShow(rcfg))
+	cmd.AddCommand(configValidate(rcfg))
+	cmd.AddCommand(configRender(rcfg))
+	cmd.AddCommand(configDiff(rcfg))
+
+	return cmd
+}
+
+func configShow(rcfg *model.RuntimeConfig) *cobra.Command {
+	var output string
+	cmd := &cobra.Command{
+		Use:   "show",
+		Short: "Show the cached config with secrets redacted and the effective addon policies",
+		RunE: func(_ *cobra.Command, _ []string) error {
+			var (
+				cfg *model.HCloudK3OSConfig
+				err error
+			)
+
+			if cfg, err = store.LoadCachedConfig(); err != nil {
+				return fmt.Errorf("error loading cached config: %v", err)
+			}
+
+			return printConfig(os.Stdout, &shownConfig{HCloudK3OSConfig: *cfg.Redacted(), Addons: generator.Addons(cfg)}, output)
+		},
+	}
+	cmd.Flags().StringVarP(&output, "output", "o", "yaml", "Output format, yaml or json")
+	return cmd
+}
+
+func configValidate(rcfg *model.RuntimeConfig) *cobra.Command {
+	var (
+		userDataPath     string
+		serverPath       string
+		networkPaths     []string
+		floatingIPsPath  string
+		masterServerPath string
+	)
+	cmd := &cobra.Command{
+		Use:   "validate",
+		Short: "Validate a user data file and a server JSON by generating the config from them",
+		RunE: func(_ *cobra.Command, _ []string) error {
+			var (
+				in  = &fetch.Inputs{Networks: map[string]*api.Network{}, Offline: true}
+				cfg *model.HCloudK3OSConfig
+				buf []byte
+				err error
+			)
+
+			if buf, err = ioutil.ReadFile(userDataPath); err != nil {
+				return fmt.Errorf("error reading user data: %v", err)
+			}
+			if in.UserConfig, err = api.ParseUserConfig(buf); err != nil {
+				return fmt.Errorf("invalid user data: %w", err)
+			}
+
+			if buf, err = ioutil.ReadFile(serverPath); err != nil {
+				return fmt.Errorf("error reading server: %v", err)
+			}
+			if in.Server, err = api.ParseServer(buf); err != nil {
+				return fmt.Errorf("invalid server: %w", err)
+			}
+
+			for _, networkPath := range networkPaths {
+				var network *api.Network
+				if buf, err = ioutil.ReadFile(networkPath); err != nil {
+					return fmt.Errorf("error reading network: %v", err)
+				}
+				if network, err = api.ParseNetwork(buf); err != nil {
+					return fmt.Errorf("invalid network %s: %w", networkPath, err)
+				}
+				in.Networks[network.ID] = network
+			}
+
+			if len(floatingIPsPath) > 0 {
+				if buf, err = ioutil.ReadFile(floatingIPsPath); err != nil {
+					return fmt.Errorf("error reading floating IPs: %v", err)
+				}
+				if in.FloatingIPs, err = api.ParseFloatingIPs(buf); err != nil {
+					return fmt.Errorf("invalid floating IPs: %w", err)
+				}
+			}
+
+			if len(masterServerPath) > 0 {
+				if buf, err = ioutil.ReadFile(masterServerPath); err != nil {
+					return fmt.Errorf("error reading master server: %v", err)
+				}
+				if in.MasterServer, err = api.ParseServer(buf); err != nil {
+					return fmt.Errorf("invalid master server: %w", err)
+				}
+			} else if in.Server.Labels["role"] == model.RoleMaster {
+				in.MasterServer = in.Server
+			} else {
+				return fmt.Errorf("--master-server is required unless the server has the label role=%s", model.RoleMaster)
+			}
+
+			if cfg, err = fetch.Generate(in); err != nil {
+				return fmt.Errorf("invalid config: %w", err)
+			}
+			if unknown := generator.UnknownAddons(cfg); len(unknown) > 0 {
+				return fmt.Errorf("invalid config: addon policies for unknown addons %s", strings.Join(unknown, ", "))
+			}
+
+			fmt.Printf("Config for %s node %s is valid\n", cfg.NodeConfig.Role, cfg.NodeConfig.Name)
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&userDataPath, "user-data", "", "User data YAML file")
+	cmd.Flags().StringVar(&serverPath, "server", "", "Server JSON file as returned by GET /servers/{id}")
+	cmd.Flags().StringSliceVar(&networkPaths, "network", nil, "Network JSON file as returned by GET /networks/{id}, may be repeated")
+	cmd.Flags().StringVar(&floatingIPsPath, "floating-ips", "", "Floating IPs JSON file as returned by GET /floating_ips")
+	cmd.Flags().StringVar(&masterServerPath, "master-server", "", "Master server JSON file, required unless the server is the master")
+	_ = cmd.MarkFlagRequired("user-data")
+	_ = cmd.MarkFlagRequired("server")
+	return cmd
+}
+
+func configRender(rcfg *model.RuntimeConfig) *cobra.Command {
+	var (
+		outputDir  string
+		cached     bool
+		bundleDir  string
+		instanceID string
+	)
+	cmd := &cobra.Command{
+		Use:   "render",
+		Short: "Render all config files into a directory instead of the system paths",
+		RunE: func(_ *cobra.Command, _ []string) error {
+			if len(bundleDir) > 0 && len(instanceID) == 0 {
+				return fmt.Errorf("--instance-id is required with --bundle")
+			}
+
+			ctx, cancel := signalContext()
+			defer cancel()
+
+			var (
+				cfg *model.HCloudK3OSConfig
+				err error
+			)
+
+			switch {
+			case len(bundleDir) > 0:
+				cfg, err = fetch.RunBundle(bundleDir, instanceID)
+			case cached:
+				cfg, err = store.LoadCachedConfig()
+			default:
+				cfg, err = fetch.Run(ctx, fetchMode(rcfg), apiOptions(rcfg)...)
+			}
+			if err != nil {
+				return fmt.Errorf("error loading config: %v", err)
+			}
+
+			var client *http.Client
+			if client, err = downloadClient(rcfg); err != nil {
+				return fmt.Errorf("error creating download client: %v", err)
+			}
+
+			return renderConfig(ctx, rcfg.Logger, rcfg.Dry, client, cfg, outputDir)
+		},
+	}
+	cmd.Flags().StringVar(&outputDir, "output-dir", "", "Directory the config files are written to, below their system paths")
+	cmd.Flags().BoolVar(&cached, "cached", false, "Render the cached config instead of fetching it")
+	cmd.Flags().StringVar(&bundleDir, "bundle", "", "Generate the config offline from a directory laid out like test/api-mock/fixtures instead of fetching it")
+	cmd.Flags().StringVar(&instanceID, "instance-id", "", "Server ID of the node in the bundle")
+	_ = cmd.MarkFlagRequired("output-dir")
+	return cmd
+}
+
+// renderConfig writes the files of all generators for cfg below outputDir unless dry and prints a report, client downloads the addon bundles and extra manifests
+func renderConfig(ctx context.Context, log *logrus.Logger, dry bool, client *http.Client, cfg *model.HCloudK3OSConfig, outputDir string) error {
+	tmpdir, err := ioutil.TempDir("", "*-hcloud-k3os")
+	if err != nil {
+		return fmt.Errorf("error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	var (
+		env    = &generator.Env{Log: log, Addons: addon.NewLoader(log, client, tmpdir, addon.DefaultLocalDir), HTTPClient: client, Dry: dry}
+		failed int
+		table  = tablewriter.NewWriter(os.Stdout)
+	)
+	table.SetHeader([]string{"Generator", "Path", "Status", "Error"})
+	for _, g := range generator.All() {
+		res := generator.Run(ctx, env, outputDir, g, cfg)
+		var msg string
+		if res.Err != nil {
+			failed++
+			msg = res.Err.Error()
+		}
+		table.Append([]string{res.Name, res.Path, string(res.Status), msg})
+	}
+	table.Render()
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d generators failed", failed, len(generator.All()))
+	}
+	return nil
+}
+
+// shownConfig is the config with the effective policies of the addons
+type shownConfig struct {
+	model.HCloudK3OSConfig `yaml:",inline"`
+	Addons                 map[string]*generator.AddonStatus `yaml:"addons"`
+}
+
+// printConfig writes cfg in the given format, which is yaml or json
+func printConfig(w io.Writer, cfg interface{}, format string) error {
+	buf, err := yaml.Marshal(cfg)
+	if err != nil {
+		return fmt.Errorf("error marshalling config to YAML: %v", err)
+	}
+	switch format {
+	case "yaml":
+	case "json":
+		// converting the YAML keeps the field names and formats of the cached config
+		var v interface{}
+		if err = yaml.Unmarshal(buf, &v); err != nil {
+			return fmt.Errorf("error unmarshalling YAML: %v", err)
+		}
+		if buf, err = json.MarshalIndent(jsonValue(v), "", "  "); err != nil {
+			return fmt.Errorf("error marshalling config to JSON: %v", err)
+		}
+		buf = append(buf, '\n')
+	default:
+		return fmt.Errorf("unknown output format '%s'", format)
+	}
+	_, err = w.Write(buf)
+	return err
+}
+
+// jsonValue converts the maps of a value decoded from YAML to maps with string keys
+func jsonValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, val := range v {
+			m[fmt.Sprint(k)] = jsonValue(val)
+		}
+		return m
+	case []interface{}:
+		for i, val := range v {
+			v[i] = jsonValue(val)
+		}
+		return v
+	default:
+		return v
+	}
+}
+
+func configDiff(rcfg *model.RuntimeConfig) *cobra.Command {
+	return &cobra.Command{
+		Use:   "diff",
+		Short: "Show the changes between the cached config and a freshly fetched config",
+		RunE: func(_ *cobra.Command, _ []string) error {
+			ctx, cancel := signalContext()
+			defer cancel()
+
+			var (
+				cached  *model.HCloudK3OSConfig
+				fetched *model.HCloudK3OSConfig
+				err     error
+			)
+
+			if cached, err = store.LoadCachedConfig(); err != nil {
+				rcfg.Logger.WithError(err).Warn("Unable to load cached config, comparing with an empty config")
+			}
+
+			if fetched, err = fetch.Run(ctx, fetchMode(rcfg), apiOptions(rcfg)...); err != nil {
+				return fmt.Errorf("error fetching config: %v", err)
+			}
+
+			changes := model.Diff(cached, fetched)
+			if len(changes) == 0 {
+				fmt.Println("No changes")
+				return nil
+			}
+
+			table := tablewriter.NewWriter(os.Stdout)
+			table.SetHeader([]string{"Field", "Change", "Old", "New"})
+
+			for _, c := range changes {
+				table.Append([]string{c.Field, string(c.Kind), c.Old, c.New})
+			}
+
+			table.Render()
+			return nil
+		},
+	}
+}
+
+// logConfigDiff logs the changes from old to new
+func logConfigDiff(log *logrus.Logger, old, new *model.HCloudK3OSConfig) {
+	for _, c := range model.Diff(old, new) {
+		log.WithFields(logrus.Fields{"field": c.Field, "change": c.Kind}).Infof("Config change: %s", c)
+	}
+}
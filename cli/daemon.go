@@ -1,42 +1,77 @@
 package cli
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io/ioutil"
-	"net"
+	"net/http"
 	"os"
 	"os/signal"
-	"path"
 	"syscall"
 	"time"
 
 	"github.com/avast/retry-go"
 	"github.com/robfig/cron/v3"
+	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 
+	"github.com/shark/hcloud-k3os-configurator/addon"
 	"github.com/shark/hcloud-k3os-configurator/backup"
 	"github.com/shark/hcloud-k3os-configurator/cmd"
-	"github.com/shark/hcloud-k3os-configurator/kustomize"
+	"github.com/shark/hcloud-k3os-configurator/errorx"
+	"github.com/shark/hcloud-k3os-configurator/generator"
 	"github.com/shark/hcloud-k3os-configurator/model"
+	"github.com/shark/hcloud-k3os-configurator/network"
 	"github.com/shark/hcloud-k3os-configurator/store"
-	"github.com/shark/hcloud-k3os-configurator/template"
+	"github.com/shark/hcloud-k3os-configurator/store/fetch"
 )
 
 // Daemon implements the daemon command
 func Daemon(rcfg *model.RuntimeConfig) *cobra.Command {
-	return &cobra.Command{
+	var (
+		gracePeriod       time.Duration
+		forceReset        bool
+		reconcileInterval time.Duration
+		skipMarkers       bool
+	)
+	daemonCmd := &cobra.Command{
 		Use:   "daemon",
 		Short: "Runs the background daemon for configuration and backup",
 		RunE: func(_ *cobra.Command, _ []string) error {
 			var (
-				err    error
-				tmpdir string
-				log    = rcfg.Logger
-				cfg    *model.HCloudK3OSConfig
+				err      error
+				tmpdir   string
+				log      = rcfg.Logger
+				cfg      *model.HCloudK3OSConfig
+				cached   *model.HCloudK3OSConfig
+				shutdown = make(chan struct{})
 			)
 
-			if _, err = cmd.Run(&cmd.Command{Name: "rm", Arg: []string{"-f", "/var/lib/hcloud-k3os/.running"}}, log, rcfg.Dry); err != nil {
+			// On SIGINT/SIGTERM, in-flight work gets the grace period to finish before its context is cancelled
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			termChan := make(chan os.Signal, 4)
+			signal.Notify(termChan, syscall.SIGINT, syscall.SIGTERM)
+			go func() {
+				select {
+				case <-termChan:
+				case <-ctx.Done():
+					return
+				}
+				log.Info("Shutdown signal received")
+				close(shutdown)
+				select {
+				case <-time.After(gracePeriod):
+					log.Warnf("In-flight work did not finish within %s, cancelling it", gracePeriod)
+				case <-termChan:
+					log.Warn("Second shutdown signal received, cancelling in-flight work")
+				case <-ctx.Done():
+				}
+				cancel()
+			}()
+
+			if _, err = cmd.Run(ctx, &cmd.Command{Name: "rm", Arg: []string{"-f", "/var/lib/hcloud-k3os/.running"}}, log, rcfg.Dry); err != nil {
 				log.WithError(err).Error("Error deleting .running file")
 			}
 
@@ -46,170 +81,73 @@ func Daemon(rcfg *model.RuntimeConfig) *cobra.Command {
 
 			if tmpdir, err = ioutil.TempDir("", "*-hcloud-k3os"); err != nil {
 				log.WithError(err).Fatal("Error creating temp dir")
-			} else {
-				defer os.RemoveAll(tmpdir)
-				if err = kustomize.Extract(tmpdir); err != nil {
-					log.WithError(err).Fatal("Error extracting kustomize files")
-				}
-			}
-
-			if cfg, err = store.ConfigureAndLoad(log, rcfg.Dry); err != nil {
-				log.WithError(err).Fatal("Loading config failed")
-			}
-
-			log.Info("Generating and writing configuration files")
-			if err = template.GenerateK3OSConfig("/var/lib/rancher/k3os/config.d/hcloud-k3os.yaml", cfg); err != nil {
-				log.WithError(err).Error("Error generating k3os config")
-			}
-
-			if err = template.GenerateDNSConfig("/etc/resolv.conf"); err != nil {
-				log.WithError(err).Error("Error generating DNS config")
 			}
+			defer os.RemoveAll(tmpdir)
 
-			if err = template.GenerateIptablesConfig("/etc/iptables/rules-save", cfg.NodeConfig.PrivateNetwork); err != nil {
-				log.WithError(err).Error("Error generating iptables config")
+			if cached, err = store.LoadCachedConfig(); err != nil {
+				log.WithError(err).Debug("No cached config to compare the fetched config with")
 			}
 
-			if err = template.GenerateIP6tablesConfig("/etc/iptables/rules6-save"); err != nil {
-				log.WithError(err).Error("Error generating iptables config")
+			var backend network.Backend
+			if backend, err = network.NewNetlinkBackend(); err != nil {
+				log.WithError(err).Fatal("Error creating network backend")
 			}
+			netcfg := network.NewConfigurator(backend, log, rcfg.Dry)
 
-			if cfg.NodeConfig.Role == model.RoleMaster && cfg.ClusterConfig.FluxConfig != nil {
-				if err = template.GenerateFluxConfig(path.Join(tmpdir, "flux", "patch.yaml"), cfg.ClusterConfig.FluxConfig); err != nil {
-					log.WithError(err).Error("Error generating Flux config")
-				} else {
-					if _, err = cmd.Run(&cmd.Command{Name: "sh", Arg: []string{"-c", fmt.Sprintf("kubectl kustomize %s > /var/lib/rancher/k3s/server/manifests/flux.yaml", path.Join(tmpdir, "flux"))}}, log, false); err != nil {
-						log.WithError(err).Error("Error running kustomize for Flux")
-					}
+			if cfg, err = store.ConfigureAndLoad(ctx, log, rcfg.Dry, fetchMode(rcfg), apiOptions(rcfg)...); err != nil {
+				var incomplete *fetch.IncompleteError
+				if !errors.As(err, &incomplete) {
+					log.WithError(err).Fatal("Loading config failed")
 				}
-			} else {
-				log.Debug("Flux is disabled")
-			}
-
-			if err = template.GenerateHCloudCSIConfig(path.Join(tmpdir, "hcloud-csi", "secret.yaml"), cfg.ClusterConfig.HCloudToken); err != nil {
-				log.WithError(err).Error("Error generating HCloud CSI config")
-			} else {
-				if _, err = cmd.Run(&cmd.Command{Name: "sh", Arg: []string{"-c", fmt.Sprintf("kubectl kustomize %s > /var/lib/rancher/k3s/server/manifests/hcloud-csi.yaml", path.Join(tmpdir, "hcloud-csi"))}}, log, false); err != nil {
-					log.WithError(err).Error("Error running kustomize for HCloud CSI")
+				log.WithError(err).Warn("Only the node's networking is available, configuring the network until the config can be loaded")
+				if cfg, err = loadWithNetworking(ctx, rcfg, netcfg, incomplete.Config); err != nil {
+					log.WithError(err).Fatal("Loading config failed")
 				}
 			}
-
-			if err = template.GenerateHCloudFIPConfig(path.Join(tmpdir, "hcloud-fip", "config.yaml"), cfg.ClusterConfig.HCloudToken, cfg.NodeConfig.FloatingIPs); err != nil {
-				log.WithError(err).Error("Error generating HCloud FIP config")
-			} else {
-				if _, err = cmd.Run(&cmd.Command{Name: "sh", Arg: []string{"-c", fmt.Sprintf("kubectl kustomize %s > /var/lib/rancher/k3s/server/manifests/hcloud-fip.yaml", path.Join(tmpdir, "hcloud-fip"))}}, log, false); err != nil {
-					log.WithError(err).Error("Error running kustomize for HCloud FIP")
-				}
+			if cached != nil {
+				logConfigDiff(log, cached, cfg)
 			}
+			warnUnknownAddons(log, cfg)
 
-			if cfg.NodeConfig.Role == model.RoleMaster && cfg.ClusterConfig.SealedSecretsConfig != nil {
-				if err = template.GenerateSealedSecretsConfig(path.Join(tmpdir, "sealed-secrets", "secret.yaml"), cfg.ClusterConfig.SealedSecretsConfig); err != nil {
-					log.WithError(err).Error("Error generating SealedSecrets config")
-				} else {
-					if _, err = cmd.Run(&cmd.Command{Name: "sh", Arg: []string{"-c", fmt.Sprintf("kubectl kustomize %s > /var/lib/rancher/k3s/server/manifests/sealed-secrets.yaml", path.Join(tmpdir, "sealed-secrets"))}}, log, false); err != nil {
-						log.WithError(err).Error("Error running kustomize for SealedSecrets")
-					}
+			if forceReset {
+				log.Info("Resetting network interfaces")
+				if err = netcfg.Reset(ctx, "eth"); err != nil {
+					log.WithError(err).Error("Error resetting network interfaces")
 				}
-			} else {
-				log.Debug("SealedSecrets is disabled")
-			}
-
-			log.Info("Resetting network interfaces")
-			if _, err = cmd.Run(&cmd.Command{Name: "sh", Arg: []string{"-c", "for i in /sys/class/net/eth*; do ip link set down $(basename $i) && ip addr flush $(basename $i) && ip link set up $(basename $i); done"}}, log, rcfg.Dry); err != nil {
-				log.WithError(err).Error("Error resetting network interfaces")
 			}
 
-			log.Info("Configuring public IPv4")
-			cmds := []*cmd.Command{}
-			for _, ip := range cfg.NodeConfig.PublicNetwork.IPv4Addresses {
-				cmds = append(cmds, &cmd.Command{Name: "ip", Arg: []string{"-4", "addr", "add", ip.Net.String(), "dev", cfg.NodeConfig.PublicNetwork.NetDeviceName}})
-			}
-			cmds = append(
-				cmds,
-				&cmd.Command{Name: "ip", Arg: []string{"-4", "route", "add", cfg.NodeConfig.PublicNetwork.GatewayIPv4.String(), "dev", "eth0", "src", cfg.NodeConfig.PublicNetwork.IPv4Addresses[0].Net.IP.String()}},
-				&cmd.Command{Name: "ip", Arg: []string{"-4", "route", "add", "default", "via", cfg.NodeConfig.PublicNetwork.GatewayIPv4.String()}},
-			)
-			if err = cmd.RunMultiple(log, rcfg.Dry, cmds); err != nil {
-				log.WithError(err).Error("Error configuring public IPv4")
-			}
-
-			log.Info("Configuring public IPv6")
-			cmds = []*cmd.Command{}
-			for _, ip := range cfg.NodeConfig.PublicNetwork.IPv6Addresses {
-				cmds = append(
-					cmds,
-					&cmd.Command{Name: "ip", Arg: []string{"-6", "addr", "add", ip.Net.String(), "dev", "eth0"}},
-				)
-			}
-			if err = cmd.RunMultiple(log, rcfg.Dry, cmds); err != nil {
-				log.WithError(err).Error("Error configuring public IPv6")
-			}
-
-			// Sleep is required for IPv6 default route to be added reliably
-			// TODO debug why we need a sleep for this
-			time.Sleep(3 * time.Second)
-
-			log.Info("Configuring IPv6 default route")
-			if err = retry.Do(func() error {
-				var runErr error
-				if _, runErr = cmd.Run(&cmd.Command{Name: "ip", Arg: []string{"-6", "route", "add", "default", "via", "fe80::1", "src", cfg.NodeConfig.PublicNetwork.IPv6Addresses[0].Net.IP.String(), "dev", "eth0"}}, log, rcfg.Dry); err != nil {
-					log.WithError(err).Error("error set route")
-					var cmdErr *cmd.Error
-					if errors.As(runErr, &cmdErr) {
-						// IPv6 not ready yet, retry
-						if cmdErr.ExitCode() == 2 {
-							return runErr
-						}
-					}
-					return retry.Unrecoverable(runErr)
-				}
-				return nil
-			}, retry.Delay(1*time.Second)); err != nil {
-				log.WithError(err).Error("Error adding IPv6 default route")
+			var client *http.Client
+			if client, err = downloadClient(rcfg); err != nil {
+				log.WithError(err).Fatal("Error creating download client")
 			}
 
-			log.Info("Configuring private network")
-			cmds = []*cmd.Command{}
-			for _, ip := range cfg.NodeConfig.PrivateNetwork.IPv4Addresses {
-				cmds = append(
-					cmds,
-					&cmd.Command{Name: "ip", Arg: []string{"-4", "addr", "add", fmt.Sprintf("%s/32", ip.Net.IP.String()), "dev", cfg.NodeConfig.PrivateNetwork.NetDeviceName}},
-				)
-			}
-			var cnet *net.IPNet
-			if cnet, err = cfg.NodeConfig.PrivateNetwork.IPv4Addresses[0].CanonicalNet(); err == nil {
-				cmds = append(
-					cmds,
-					&cmd.Command{Name: "ip", Arg: []string{"-4", "route", "add", cfg.NodeConfig.PrivateNetwork.GatewayIPv4.String(), "dev", cfg.NodeConfig.PrivateNetwork.NetDeviceName}},
-					&cmd.Command{Name: "ip", Arg: []string{"-4", "route", "add", cnet.String(), "via", cfg.NodeConfig.PrivateNetwork.GatewayIPv4.String()}},
-				)
-			}
-			if err = cmd.RunMultiple(log, rcfg.Dry, cmds); err != nil {
-				log.WithError(err).Error("Error configuring private network")
+			r := newReconciler(log, daemonSteps(rcfg, client, tmpdir, skipMarkers, netcfg))
+			if err = r.apply(ctx, cfg); err != nil {
+				log.WithError(err).Error("Configuration failed, the failed steps are applied again on the next reconciliation")
+			} else {
+				log.Info("Configuration successful!")
 			}
 
-			log.Info("Configuration successful!")
-
 			if !backup.IsBootstrapped() {
-				if err = backup.Init(cfg.ClusterConfig.BackupConfig, log, false); err != nil {
+				if err = backup.Init(ctx, cfg.ClusterConfig.BackupConfig, log, false); err != nil {
 					log.WithError(err).Fatal("Unable to initialize backup")
 				}
 
 				if !cfg.ClusterConfig.Bootstrap {
 					var snapshots []*backup.Snapshot
-					if snapshots, err = backup.ListSnapshots(cfg.ClusterConfig.BackupConfig, log, false); err != nil {
+					if snapshots, err = backup.ListSnapshots(ctx, cfg.ClusterConfig.BackupConfig, log, false); err != nil {
 						log.WithError(err).Fatal("Unable to list snapshots")
 					}
 					if len(snapshots) == 0 {
 						log.Fatal("Backup does not have any snapshots")
 					}
 					log.Info("Restoring latest snapshot from backup")
-					if err = backup.Restore(cfg.ClusterConfig.BackupConfig, log, false); err != nil {
+					if err = backup.Restore(ctx, cfg.ClusterConfig.BackupConfig, log, false); err != nil {
 						log.WithError(err).Fatal("Unable to bootstrap node")
 					}
 					log.Info("Snapshot restored")
 				} else {
-					if err = backup.MarkBootstrapped(log, false); err != nil {
+					if err = backup.MarkBootstrapped(ctx, log, false); err != nil {
 						log.WithError(err).Fatal("Unable to mark node as bootstrapped")
 					}
 					log.Info("Bootstrap mode, marked node as bootstrapped")
@@ -217,9 +155,31 @@ func Daemon(rcfg *model.RuntimeConfig) *cobra.Command {
 			}
 
 			c := cron.New()
+			if reconcileInterval > 0 {
+				if _, err = c.AddFunc(fmt.Sprintf("@every %s", reconcileInterval), func() {
+					var (
+						newCfg *model.HCloudK3OSConfig
+						err    error
+					)
+					log.Debug("Reconciling configuration")
+					if newCfg, err = store.LoadAndCache(ctx, log, fetchMode(rcfg), apiOptions(rcfg)...); err != nil {
+						log.WithError(err).Error("Error loading config for reconciliation")
+						return
+					}
+					logConfigDiff(log, r.config(), newCfg)
+					warnUnknownAddons(log, newCfg)
+					if err = r.apply(ctx, newCfg); err != nil {
+						log.WithError(err).Error("Error reconciling configuration")
+					}
+				}); err != nil {
+					log.WithError(err).Error("Error creating job for periodic reconciliation")
+				}
+			}
 			if _, err = c.AddFunc("@every 8h", func() {
 				var err error
-				if err = backup.Backup(cfg.ClusterConfig.BackupConfig, log, rcfg.Dry); err != nil {
+				if err = retry.Do(func() error {
+					return backup.Backup(ctx, r.config().ClusterConfig.BackupConfig, log, rcfg.Dry)
+				}, retry.Context(ctx), retry.RetryIf(errorx.IsRetryable), retry.Attempts(3), retry.Delay(1*time.Minute), retry.LastErrorOnly(true)); err != nil {
 					log.WithError(err).Error("Error running periodic backup")
 				}
 			}); err != nil {
@@ -227,22 +187,112 @@ func Daemon(rcfg *model.RuntimeConfig) *cobra.Command {
 			}
 			c.Start()
 
-			if _, err = cmd.Run(&cmd.Command{Name: "touch", Arg: []string{"/var/lib/hcloud-k3os/.running"}}, log, false); err != nil {
+			if _, err = cmd.Run(ctx, &cmd.Command{Name: "touch", Arg: []string{"/var/lib/hcloud-k3os/.running"}}, log, false); err != nil {
 				log.WithError(err).Error("Error creating .running file")
 			}
 
-			termChan := make(chan os.Signal, 4)
-			signal.Notify(termChan, syscall.SIGINT, syscall.SIGTERM)
-
-			<-termChan
+			<-shutdown
 
-			log.Info("Shutdown signal received")
+			// wait for a running backup to finish or be cancelled
+			select {
+			case <-c.Stop().Done():
+			case <-ctx.Done():
+			}
 
-			if _, err = cmd.Run(&cmd.Command{Name: "rm", Arg: []string{"-f", "/var/lib/hcloud-k3os/.running"}}, log, false); err != nil {
+			if _, err = cmd.Run(context.Background(), &cmd.Command{Name: "rm", Arg: []string{"-f", "/var/lib/hcloud-k3os/.running"}}, log, false); err != nil {
 				log.WithError(err).Error("Error deleting .running file")
 			}
 
 			return nil
 		},
 	}
+	daemonCmd.Flags().DurationVar(&gracePeriod, "shutdown-grace-period", 30*time.Second, "Time in-flight work gets to finish after a shutdown signal before it is cancelled")
+	daemonCmd.Flags().BoolVar(&forceReset, "force-reset", false, "Bring down and flush all eth* interfaces before configuring the network instead of only changing what differs")
+	daemonCmd.Flags().BoolVar(&skipMarkers, "skip-disabled-manifests", false, "Also create the k3s .skip marker when deleting the manifest of a disabled addon")
+	daemonCmd.Flags().DurationVar(&reconcileInterval, "reconcile-interval", 5*time.Minute, "Interval in which the config is fetched again and changes are applied, 0 disables reconciliation")
+	return daemonCmd
+}
+
+// daemonSteps returns the steps which configure the node, addon bundles and extra manifests are downloaded with client, bundles are extracted to tmpdir.
+// The network comes first because the generators may download files and the DHCP lease was released after loading the config.
+func daemonSteps(rcfg *model.RuntimeConfig, client *http.Client, tmpdir string, skipMarkers bool, netcfg *network.Configurator) []*step {
+	env := &generator.Env{
+		Log:         rcfg.Logger,
+		Addons:      addon.NewLoader(rcfg.Logger, client, tmpdir, addon.DefaultLocalDir),
+		HTTPClient:  client,
+		Dry:         rcfg.Dry,
+		SkipMarkers: skipMarkers,
+		Owner:       &generator.Owner{UID: 0, GID: 0},
+		RecordPath:  generator.RecordPath,
+	}
+	return append([]*step{{
+		name: "network",
+		input: func(cfg *model.HCloudK3OSConfig) interface{} {
+			return []interface{}{cfg.NodeConfig.PublicNetwork, cfg.NodeConfig.PrivateNetworks}
+		},
+		apply: func(ctx context.Context, cfg *model.HCloudK3OSConfig) error {
+			return applyNetwork(ctx, netcfg, cfg)
+		},
+	}}, generatorSteps(env, "/")...)
+}
+
+// applyNetwork configures the node's network of cfg
+func applyNetwork(ctx context.Context, netcfg *network.Configurator, cfg *model.HCloudK3OSConfig) error {
+	desired, err := network.DesiredState(cfg.NodeConfig)
+	if err != nil {
+		return fmt.Errorf("error building network configuration: %w", err)
+	}
+	return netcfg.Apply(ctx, desired)
+}
+
+// loadRetryInterval is the time between the attempts of loadWithNetworking to load the config
+const loadRetryInterval = 1 * time.Minute
+
+// loadWithNetworking configures the network of networking, which only has the node's networking, and loads the config until it succeeds
+func loadWithNetworking(ctx context.Context, rcfg *model.RuntimeConfig, netcfg *network.Configurator, networking *model.HCloudK3OSConfig) (*model.HCloudK3OSConfig, error) {
+	if err := applyNetwork(ctx, netcfg, networking); err != nil {
+		return nil, err
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(loadRetryInterval):
+		}
+		cfg, err := store.LoadAndCache(ctx, rcfg.Logger, fetchMode(rcfg), apiOptions(rcfg)...)
+		if err == nil {
+			return cfg, nil
+		}
+		rcfg.Logger.WithError(err).Warnf("Loading config failed, trying again in %s", loadRetryInterval)
+	}
+}
+
+// generatorSteps returns a step for each registered generator which writes its file below root
+func generatorSteps(env *generator.Env, root string) []*step {
+	var steps []*step
+	for _, g := range generator.All() {
+		g := g
+		steps = append(steps, &step{
+			name:  g.Name(),
+			input: g.Input,
+			apply: func(ctx context.Context, cfg *model.HCloudK3OSConfig) error {
+				res := generator.Run(ctx, env, root, g, cfg)
+				switch res.Status {
+				case generator.StatusDisabled:
+					env.Log.Debugf("%s is disabled", res.Name)
+				case generator.StatusWritten:
+					env.Log.Debugf("%s written to %s", res.Name, res.Path)
+				}
+				return res.Err
+			},
+		})
+	}
+	return steps
+}
+
+// warnUnknownAddons warns about addon policies which have no effect because the addon does not exist
+func warnUnknownAddons(log *logrus.Logger, cfg *model.HCloudK3OSConfig) {
+	for _, name := range generator.UnknownAddons(cfg) {
+		log.Warnf("Ignoring the policy %s of the unknown addon '%s'", cfg.NodeConfig.AddonPolicy(name), name)
+	}
 }
@@ -0,0 +1,91 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+
+	"github.com/shark/hcloud-k3os-configurator/model"
+)
+
+// step is a part of the node configuration which only has to be applied again when its input changes
+type step struct {
+	name string
+	// input returns the part of the config the step depends on, nil means the step is only applied once
+	input func(cfg *model.HCloudK3OSConfig) interface{}
+	apply func(ctx context.Context, cfg *model.HCloudK3OSConfig) error
+}
+
+// reconciler applies the steps and remembers the config they were applied with
+type reconciler struct {
+	log   *logrus.Logger
+	steps []*step
+
+	// mu serializes apply, cfgMu only guards cfg, so config does not wait for an apply, e.g. one downloading files
+	mu     sync.Mutex
+	failed map[string]bool
+	cfgMu  sync.Mutex
+	cfg    *model.HCloudK3OSConfig
+}
+
+// newReconciler creates a reconciler for steps which have not been applied yet
+func newReconciler(log *logrus.Logger, steps []*step) *reconciler {
+	return &reconciler{
+		log:    log,
+		steps:  steps,
+		failed: map[string]bool{},
+	}
+}
+
+// config returns the config which was applied last
+func (r *reconciler) config() *model.HCloudK3OSConfig {
+	r.cfgMu.Lock()
+	defer r.cfgMu.Unlock()
+	return r.cfg
+}
+
+// apply applies all steps on the first run, later only the steps whose input changed or which failed before
+func (r *reconciler) apply(ctx context.Context, cfg *model.HCloudK3OSConfig) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	prev := r.config()
+	defer func() {
+		r.cfgMu.Lock()
+		defer r.cfgMu.Unlock()
+		r.cfg = cfg
+	}()
+	for _, s := range r.steps {
+		switch {
+		case prev == nil:
+			r.log.Infof("Applying %s", s.name)
+		case r.failed[s.name]:
+			r.log.Infof("Applying %s again, it failed last time", s.name)
+		case s.input != nil && !sameInput(s.input(prev), s.input(cfg)):
+			r.log.Infof("Config of %s changed, applying it again", s.name)
+		default:
+			r.log.Debugf("Config of %s is unchanged", s.name)
+			continue
+		}
+		if err := s.apply(ctx, cfg); err != nil {
+			r.log.WithError(err).Errorf("Error applying %s", s.name)
+			r.failed[s.name] = true
+			continue
+		}
+		delete(r.failed, s.name)
+	}
+	if len(r.failed) > 0 {
+		return fmt.Errorf("%d of %d steps failed", len(r.failed), len(r.steps))
+	}
+	return nil
+}
+
+// sameInput compares step inputs by their YAML representation, which is also how the config is cached
+func sameInput(a, b interface{}) bool {
+	bufA, errA := yaml.Marshal(a)
+	bufB, errB := yaml.Marshal(b)
+	return errA == nil && errB == nil && bytes.Equal(bufA, bufB)
+}
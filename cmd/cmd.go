@@ -1,6 +1,7 @@
 package cmd
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"os"
@@ -12,8 +13,9 @@ import (
 
 // Error represents a failed cmd run error
 type Error struct {
-	err      error
-	exitCode int
+	err       error
+	exitCode  int
+	temporary bool
 }
 
 func (c *Error) Error() string {
@@ -30,8 +32,13 @@ func (c *Error) Unwrap() error {
 	return c.err
 }
 
+// Temporary implements errorx.Temporary, it is true if the exit code is one of the command's TemporaryExitCodes
+func (c *Error) Temporary() bool {
+	return c.temporary
+}
+
 // Run executes a command
-func Run(cmd *Command, log *logrus.Logger, dry bool) (string, error) {
+func Run(ctx context.Context, cmd *Command, log *logrus.Logger, dry bool) (string, error) {
 	var (
 		out []byte
 		err error
@@ -41,7 +48,7 @@ func Run(cmd *Command, log *logrus.Logger, dry bool) (string, error) {
 		log.Debugf("Dry run, not executing %s %#v", cmd.Name, cmd.Arg)
 		return "", nil
 	}
-	ecmd := exec.Command(cmd.Name, cmd.Arg...)
+	ecmd := exec.CommandContext(ctx, cmd.Name, cmd.Arg...)
 	if cmd.Env != nil {
 		ecmd.Env = os.Environ()
 		for k, v := range cmd.Env {
@@ -53,8 +60,9 @@ func Run(cmd *Command, log *logrus.Logger, dry bool) (string, error) {
 		if errors.As(err, &exiterr) {
 			if status, ok := exiterr.Sys().(syscall.WaitStatus); ok {
 				return string(out), &Error{
-					err:      err,
-					exitCode: status.ExitStatus(),
+					err:       err,
+					exitCode:  status.ExitStatus(),
+					temporary: cmd.isTemporaryExitCode(status.ExitStatus()),
 				}
 			}
 		}
@@ -69,13 +77,25 @@ type Command struct {
 	Name string
 	Arg  []string
 	Env  map[string]string
+
+	// TemporaryExitCodes are exit codes which mean the command may succeed when retried
+	TemporaryExitCodes []int
+}
+
+func (c *Command) isTemporaryExitCode(exitCode int) bool {
+	for _, code := range c.TemporaryExitCodes {
+		if code == exitCode {
+			return true
+		}
+	}
+	return false
 }
 
 // RunMultiple runs a pipeline of commands and fails early if any command fails
-func RunMultiple(log *logrus.Logger, dry bool, commands []*Command) error {
+func RunMultiple(ctx context.Context, log *logrus.Logger, dry bool, commands []*Command) error {
 	var err error
 	for _, cmd := range commands {
-		if _, err = Run(cmd, log, dry); err != nil {
+		if _, err = Run(ctx, cmd, log, dry); err != nil {
 			log.WithError(err).Errorf("Command %#v of pipeline %#v failed", cmd, commands)
 			return fmt.Errorf("running command %#v failed: %w", cmd, err)
 		}
@@ -0,0 +1,39 @@
+package download
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Timeout is the timeout of a single download including reading the file
+const Timeout = 1 * time.Minute
+
+// Verified downloads the file at url with client and checks that its hex encoded SHA-256 is checksum
+func Verified(ctx context.Context, client *http.Client, url, checksum string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error creating request for %s: %w", url, err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error downloading %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error downloading %s: got status %s", url, resp.Status)
+	}
+	buf, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error downloading %s: %w", url, err)
+	}
+	if sum := sha256.Sum256(buf); hex.EncodeToString(sum[:]) != strings.ToLower(checksum) {
+		return nil, fmt.Errorf("%s has SHA-256 %x, expected %s", url, sum, checksum)
+	}
+	return buf, nil
+}
@@ -1,11 +1,18 @@
 package errorx
 
-import "fmt"
+import (
+	"errors"
+	"fmt"
+	"time"
+)
 
 // RetryableError represents a temporary error which tells the client that the operation may succeed when retried.
 type RetryableError struct {
 	Message string
 	Err     error
+
+	// RetryAfter is a hint how long to wait before retrying, zero means no hint
+	RetryAfter time.Duration
 }
 
 // Error is the error message
@@ -17,3 +24,34 @@ func (e *RetryableError) Error() string {
 func (e *RetryableError) Unwrap() error {
 	return e.Err
 }
+
+// Temporary implements the Temporary interface, a RetryableError is always temporary
+func (e *RetryableError) Temporary() bool {
+	return true
+}
+
+// Temporary is implemented by errors which know whether the failed operation may succeed when retried
+type Temporary interface {
+	Temporary() bool
+}
+
+// IsRetryable returns true if an error in the chain is temporary or a timeout
+func IsRetryable(err error) bool {
+	var (
+		temporary Temporary
+		timeout   interface{ Timeout() bool }
+	)
+	if errors.As(err, &temporary) && temporary.Temporary() {
+		return true
+	}
+	return errors.As(err, &timeout) && timeout.Timeout()
+}
+
+// RetryAfter returns the wait hint of the first RetryableError in the error chain or zero if there is none
+func RetryAfter(err error) time.Duration {
+	var rerr *RetryableError
+	if errors.As(err, &rerr) {
+		return rerr.RetryAfter
+	}
+	return 0
+}
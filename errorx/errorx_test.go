@@ -0,0 +1,80 @@
+package errorx_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/shark/hcloud-k3os-configurator/api"
+	"github.com/shark/hcloud-k3os-configurator/errorx"
+)
+
+// timeoutError is a net.Error which timed out
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return false }
+
+var _ net.Error = timeoutError{}
+
+func TestIsRetryable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"plain error", errors.New("boom"), false},
+		{"timeout", timeoutError{}, true},
+		{"wrapped timeout", fmt.Errorf("error in http request: %w", timeoutError{}), true},
+		{"deadline exceeded", context.DeadlineExceeded, true},
+		{"context canceled", context.Canceled, false},
+		{"wrapped context canceled", fmt.Errorf("error in http request: %w", context.Canceled), false},
+		{"500", &api.Error{StatusCode: http.StatusInternalServerError}, true},
+		{"503", &api.Error{StatusCode: http.StatusServiceUnavailable}, true},
+		{"429", &api.Error{StatusCode: http.StatusTooManyRequests}, true},
+		{"400", &api.Error{StatusCode: http.StatusBadRequest}, false},
+		{"401", &api.Error{StatusCode: http.StatusUnauthorized}, false},
+		{"404", &api.Error{StatusCode: http.StatusNotFound, Code: api.ErrorCodeNotFound}, false},
+		{"409 conflict code", &api.Error{StatusCode: http.StatusConflict, Code: api.ErrorCodeConflict}, true},
+		{"423 locked code", &api.Error{StatusCode: http.StatusLocked, Code: api.ErrorCodeLocked}, true},
+		{"wrapped 500", fmt.Errorf("error getting server: %w", &api.Error{StatusCode: http.StatusBadGateway}), true},
+		{"wrapped 403", fmt.Errorf("error getting server: %w", &api.Error{StatusCode: http.StatusForbidden}), false},
+		{"retryable error", &errorx.RetryableError{Message: "retry", Err: errors.New("boom")}, true},
+		{"wrapped retryable error", fmt.Errorf("error reading user data: %w", &errorx.RetryableError{Message: "retry", Err: errors.New("boom")}), true},
+		{"retryable error wrapping 404", &errorx.RetryableError{Message: "retry", Err: &api.Error{StatusCode: http.StatusNotFound}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := errorx.IsRetryable(tt.err); got != tt.want {
+				t.Errorf("IsRetryable(%v) = %t, want %t", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want time.Duration
+	}{
+		{"nil", nil, 0},
+		{"plain error", errors.New("boom"), 0},
+		{"no hint", &errorx.RetryableError{Message: "retry", Err: errors.New("boom")}, 0},
+		{"hint", &errorx.RetryableError{Message: "retry", Err: errors.New("boom"), RetryAfter: 5 * time.Second}, 5 * time.Second},
+		{"wrapped hint", fmt.Errorf("error: %w", &errorx.RetryableError{Message: "retry", Err: errors.New("boom"), RetryAfter: time.Minute}), time.Minute},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := errorx.RetryAfter(tt.err); got != tt.want {
+				t.Errorf("RetryAfter(%v) = %s, want %s", tt.err, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,80 @@
+package generator
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+
+	"github.com/shark/hcloud-k3os-configurator/model"
+	"github.com/shark/hcloud-k3os-configurator/template"
+)
+
+// paths of the generated files on the node
+const (
+	K3OSConfigPath = "/var/lib/rancher/k3os/config.d/hcloud-k3os.yaml"
+	DNSConfigPath  = "/etc/resolv.conf"
+	IptablesPath   = "/etc/iptables/rules-save"
+	IP6tablesPath  = "/etc/iptables/rules6-save"
+)
+
+// file is a generator for a config file which is rendered from a template and enabled on all nodes
+type file struct {
+	name   string
+	target string
+	mode   os.FileMode
+	input  func(cfg *model.HCloudK3OSConfig) interface{}
+	write  func(w io.Writer, cfg *model.HCloudK3OSConfig) error
+}
+
+func init() {
+	Register(&file{
+		name:   "k3os config",
+		target: K3OSConfigPath,
+		mode:   template.SecretMode,
+		input:  func(cfg *model.HCloudK3OSConfig) interface{} { return cfg },
+		write:  template.WriteK3OSConfig,
+	})
+	Register(&file{
+		name:   "DNS config",
+		target: DNSConfigPath,
+		mode:   template.PublicMode,
+		write:  func(w io.Writer, _ *model.HCloudK3OSConfig) error { return template.WriteDNSConfig(w) },
+	})
+	Register(&file{
+		name:   "iptables config",
+		target: IptablesPath,
+		mode:   template.PublicMode,
+		input:  func(cfg *model.HCloudK3OSConfig) interface{} { return cfg.NodeConfig.PrivateNetworks },
+		write: func(w io.Writer, cfg *model.HCloudK3OSConfig) error {
+			return template.WriteIptablesConfig(w, cfg.NodeConfig.PrivateNetworks)
+		},
+	})
+	Register(&file{
+		name:   "ip6tables config",
+		target: IP6tablesPath,
+		mode:   template.PublicMode,
+		write:  func(w io.Writer, _ *model.HCloudK3OSConfig) error { return template.WriteIP6tablesConfig(w) },
+	})
+}
+
+func (f *file) Name() string { return f.name }
+
+func (f *file) Enabled(_ *model.HCloudK3OSConfig) bool { return true }
+
+func (f *file) Input(cfg *model.HCloudK3OSConfig) interface{} {
+	if f.input == nil {
+		return nil
+	}
+	return f.input(cfg)
+}
+
+func (f *file) Render(_ context.Context, _ *Env, cfg *model.HCloudK3OSConfig) ([]*File, error) {
+	var buf bytes.Buffer
+	if err := f.write(&buf, cfg); err != nil {
+		return nil, err
+	}
+	return []*File{{Path: f.target, Mode: f.mode, Content: buf.Bytes()}}, nil
+}
+
+func (f *file) Target() string { return f.target }
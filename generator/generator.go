@@ -0,0 +1,225 @@
+package generator
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/shark/hcloud-k3os-configurator/addon"
+	"github.com/shark/hcloud-k3os-configurator/atomicfile"
+	"github.com/shark/hcloud-k3os-configurator/model"
+)
+
+// Generator renders files of the node configuration, e.g. the k3os config or an addon manifest
+type Generator interface {
+	// Name identifies the generator in logs and reports
+	Name() string
+
+	// Enabled is true if the file is generated for cfg
+	Enabled(cfg *model.HCloudK3OSConfig) bool
+
+	// Input returns the part of cfg the file depends on, nil means the file only has to be generated once
+	Input(cfg *model.HCloudK3OSConfig) interface{}
+
+	// Render returns the files for cfg, their paths have to match Target
+	Render(ctx context.Context, env *Env, cfg *model.HCloudK3OSConfig) ([]*File, error)
+
+	// Target returns the absolute path of the file on the node, or a pattern matching all files the generator owns
+	Target() string
+}
+
+// File is a rendered file
+type File struct {
+	// Path is the absolute path of the file on the node
+	Path    string
+	Mode    os.FileMode
+	Content []byte
+}
+
+// Owner is the user and group owning a file
+type Owner struct {
+	UID int
+	GID int
+}
+
+// Env is the environment generators render in
+type Env struct {
+	Log *logrus.Logger
+
+	// Addons finds the bundles of the addons
+	Addons *addon.Loader
+
+	// HTTPClient downloads files, e.g. the extra manifests given by URL
+	HTTPClient *http.Client
+
+	// Dry renders the files without writing them
+	Dry bool
+
+	// SkipMarkers also creates the k3s .skip marker when the files of a disabled generator are deleted
+	SkipMarkers bool
+
+	// Owner owns the written files, e.g. root for the files with secrets, nil keeps the user running the generator
+	Owner *Owner
+
+	// RecordPath is where the generated files are recorded, nothing is recorded if it is empty, e.g. when rendering to a directory
+	RecordPath string
+}
+
+var (
+	registryMu sync.Mutex
+	registry   []Generator
+)
+
+// Register adds g to the generators, generators run in the order they are registered. It panics if a generator with the same name is registered already
+func Register(g Generator) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	for _, r := range registry {
+		if r.Name() == g.Name() {
+			panic(fmt.Sprintf("generator '%s' is registered twice", g.Name()))
+		}
+	}
+	registry = append(registry, g)
+}
+
+// All returns the registered generators in the order they were registered
+func All() []Generator {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	return append([]Generator(nil), registry...)
+}
+
+// Status is the outcome of running a generator
+type Status string
+
+const (
+	// StatusWritten means the files were written or were up to date already
+	StatusWritten Status = "written"
+
+	// StatusDryRun means the files were rendered but not written because of a dry run
+	StatusDryRun Status = "dry run"
+
+	// StatusDisabled means the generator is not enabled for the config
+	StatusDisabled Status = "disabled"
+
+	// StatusFailed means rendering or writing the files failed
+	StatusFailed Status = "failed"
+)
+
+// Result is the outcome of running a generator
+type Result struct {
+	Name string
+	// Path is the target of the generator below the root
+	Path   string
+	Status Status
+	Err    error
+}
+
+// Run renders the files of g for cfg and writes them below root unless in dry mode, files matching the target which were not rendered are deleted.
+// The files are recorded at env.RecordPath, when g is disabled the files it wrote before are deleted, and unless in dry mode also the unrecorded
+// files matching its target if env.RecordPath is set.
+func Run(ctx context.Context, env *Env, root string, g Generator, cfg *model.HCloudK3OSConfig) *Result {
+	res := &Result{Name: g.Name(), Path: path.Join(root, g.Target())}
+	rec, err := loadRecord(env.RecordPath)
+	if err != nil {
+		res.Status, res.Err = StatusFailed, err
+		return res
+	}
+
+	if !g.Enabled(cfg) {
+		res.Status = StatusDisabled
+		if err = rec.collect(env, res.Path, g.Name()); err != nil {
+			res.Status, res.Err = StatusFailed, fmt.Errorf("error deleting files of %s: %w", g.Name(), err)
+		}
+	} else {
+		res.Status, res.Err = StatusWritten, generate(ctx, env, root, rec, g, cfg)
+		if res.Err != nil {
+			res.Status = StatusFailed
+		}
+	}
+
+	if env.Dry {
+		if res.Status == StatusWritten {
+			res.Status = StatusDryRun
+		}
+		return res
+	}
+	if env.RecordPath == "" {
+		return res
+	}
+	if err = rec.save(env.RecordPath); err != nil && res.Err == nil {
+		res.Status, res.Err = StatusFailed, err
+	}
+	return res
+}
+
+// generate renders and writes the files of g and records them
+func generate(ctx context.Context, env *Env, root string, rec *record, g Generator, cfg *model.HCloudK3OSConfig) error {
+	files, err := g.Render(ctx, env, cfg)
+	if err != nil {
+		return fmt.Errorf("error rendering %s: %w", g.Name(), err)
+	}
+	paths, err := write(env, root, path.Join(root, g.Target()), files)
+	if err != nil {
+		return fmt.Errorf("error writing %s: %w", g.Name(), err)
+	}
+	if env.Dry {
+		return nil
+	}
+	return rec.written(env, g.Name(), paths)
+}
+
+// write writes files below root and deletes the files matching target which are not among them, it returns the paths of the files
+func write(env *Env, root, target string, files []*File) ([]string, error) {
+	var (
+		paths    []string
+		rendered = map[string]bool{}
+	)
+	for _, f := range files {
+		p := path.Join(root, f.Path)
+		if ok, err := path.Match(target, p); err != nil || !ok {
+			return nil, fmt.Errorf("file %s does not match the target %s", p, target)
+		}
+		rendered[p] = true
+		paths = append(paths, p)
+		if env.Dry {
+			env.Log.Infof("Dry run, not writing %s", p)
+			continue
+		}
+		if err := os.MkdirAll(path.Dir(p), 0755); err != nil {
+			return nil, fmt.Errorf("error creating directory for %s: %w", p, err)
+		}
+		opts := []atomicfile.Option{atomicfile.WithMode(f.Mode)}
+		if env.Owner != nil {
+			opts = append(opts, atomicfile.WithOwner(env.Owner.UID, env.Owner.GID))
+		}
+		if err := atomicfile.Write(p, f.Content, opts...); err != nil {
+			return nil, err
+		}
+	}
+
+	existing, err := filepath.Glob(target)
+	if err != nil {
+		return nil, fmt.Errorf("error listing files matching %s: %w", target, err)
+	}
+	for _, p := range existing {
+		if rendered[p] {
+			continue
+		}
+		if env.Dry {
+			env.Log.Infof("Dry run, not deleting %s", p)
+			continue
+		}
+		if err = os.Remove(p); err != nil {
+			return nil, fmt.Errorf("error deleting %s: %w", p, err)
+		}
+		env.Log.Infof("Deleted %s, it is no longer generated", p)
+	}
+	return paths, nil
+}
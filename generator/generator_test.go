@@ -0,0 +1,424 @@
+package generator
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/shark/hcloud-k3os-configurator/addon"
+	"github.com/shark/hcloud-k3os-configurator/api"
+	"github.com/shark/hcloud-k3os-configurator/kustomize"
+	"github.com/shark/hcloud-k3os-configurator/model"
+	"github.com/shark/hcloud-k3os-configurator/store/fetch"
+	"github.com/shark/hcloud-k3os-configurator/template"
+)
+
+var update = flag.Bool("update", false, "update the golden files in testdata/golden")
+
+const (
+	fixturesDir    = "../test/api-mock/fixtures"
+	masterID       = "4406144"
+	agentID        = "4406228"
+	goldenDir      = "testdata/golden"
+	upstreamDir    = "testdata/upstream"
+	goldenResults  = "results.txt"
+	goldenFilesDir = "files"
+)
+
+// scenarios are the configs the generators are rendered for, each one modifies the inputs from the API mock fixtures
+var scenarios = []struct {
+	name       string
+	instanceID string
+	modify     func(in *fetch.Inputs)
+}{
+	{
+		name:       "master",
+		instanceID: masterID,
+	},
+	{
+		name:       "agent",
+		instanceID: agentID,
+	},
+	{
+		name:       "master-ipv6-floating-ips",
+		instanceID: masterID,
+		modify: func(in *fetch.Inputs) {
+			var fips []*api.FloatingIP
+			for _, fip := range in.FloatingIPs {
+				if fip.Type == api.FloatingIPv6 {
+					fips = append(fips, fip)
+				}
+			}
+			in.FloatingIPs = fips
+		},
+	},
+	{
+		name:       "master-without-flux",
+		instanceID: masterID,
+		modify: func(in *fetch.Inputs) {
+			in.UserConfig.FluxGitURL, in.UserConfig.FluxGitPrivateKey = nil, nil
+		},
+	},
+	{
+		name:       "master-without-sealed-secrets",
+		instanceID: masterID,
+		modify: func(in *fetch.Inputs) {
+			in.UserConfig.SealedSecretsTLSCert, in.UserConfig.SealedSecretsTLSKey = nil, nil
+		},
+	},
+	{
+		name:       "master-without-flux-and-sealed-secrets",
+		instanceID: masterID,
+		modify: func(in *fetch.Inputs) {
+			in.UserConfig.FluxGitURL, in.UserConfig.FluxGitPrivateKey = nil, nil
+			in.UserConfig.SealedSecretsTLSCert, in.UserConfig.SealedSecretsTLSKey = nil, nil
+		},
+	},
+}
+
+func TestGolden(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "hcloud-k3os-generator-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	env := testEnv(t, tmp)
+	for _, sc := range scenarios {
+		sc := sc
+		t.Run(sc.name, func(t *testing.T) {
+			cfg := testConfig(t, sc.instanceID, sc.modify)
+			root := filepath.Join(tmp, "root", sc.name)
+			var results bytes.Buffer
+			for _, g := range All() {
+				res := Run(context.Background(), env, root, g, cfg)
+				if res.Err != nil {
+					t.Fatalf("error running %s: %v", res.Name, res.Err)
+				}
+				fmt.Fprintf(&results, "%s: %s\n", res.Name, res.Status)
+			}
+			files, modes := readTree(t, root)
+			for _, p := range sortedKeys(files) {
+				fmt.Fprintf(&results, "%s %s\n", p, modes[p])
+			}
+
+			dir := filepath.Join(goldenDir, sc.name)
+			if *update {
+				writeGolden(t, dir, results.Bytes(), files)
+				return
+			}
+			compareGolden(t, dir, results.Bytes(), files)
+		})
+	}
+}
+
+func TestAll(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "hcloud-k3os-generator-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	var (
+		env = testEnv(t, tmp)
+		cfg = testConfig(t, masterID, nil)
+		dry = *env
+	)
+	dry.Dry = true
+	cfg.ClusterConfig.ExtraManifests = []*model.ExtraManifest{{
+		Name:    "namespace",
+		Content: "apiVersion: v1\nkind: Namespace\nmetadata:\n  name: test\n",
+	}}
+
+	if len(All()) == 0 {
+		t.Fatal("no generators are registered")
+	}
+	for _, g := range All() {
+		g := g
+		t.Run(g.Name(), func(t *testing.T) {
+			if !g.Enabled(cfg) {
+				t.Fatalf("%s is not enabled for a master with all addons configured", g.Name())
+			}
+			files, err := g.Render(context.Background(), env, cfg)
+			if err != nil {
+				t.Fatalf("error rendering: %v", err)
+			}
+			for _, f := range files {
+				if ok, err := filepath.Match(g.Target(), f.Path); err != nil || !ok {
+					t.Errorf("%s does not match the target %s", f.Path, g.Target())
+				}
+				if f.Mode != template.PublicMode && f.Mode != template.SecretMode {
+					t.Errorf("%s has unexpected mode %s", f.Path, f.Mode)
+				}
+			}
+
+			root := filepath.Join(tmp, "root", g.Name())
+			if err := os.MkdirAll(root, 0755); err != nil {
+				t.Fatal(err)
+			}
+			if res := Run(context.Background(), &dry, root, g, cfg); res.Status != StatusDryRun || res.Err != nil {
+				t.Fatalf("dry run: got status %s and error %v", res.Status, res.Err)
+			}
+			if written, _ := readTree(t, root); len(written) > 0 {
+				t.Errorf("dry run wrote %d files", len(written))
+			}
+			if res := Run(context.Background(), env, root, g, cfg); res.Status != StatusWritten || res.Err != nil {
+				t.Fatalf("got status %s and error %v", res.Status, res.Err)
+			}
+			written, _ := readTree(t, root)
+			if len(files) == 0 {
+				t.Error("no files were rendered")
+			}
+			for _, f := range files {
+				if !bytes.Equal(written[f.Path], f.Content) {
+					t.Errorf("%s was not written", f.Path)
+				}
+			}
+		})
+	}
+}
+
+func TestRunDisabled(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "hcloud-k3os-generator-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	var (
+		env     = testEnv(t, tmp)
+		root    = filepath.Join(tmp, "root")
+		enabled = testConfig(t, masterID, nil)
+		flux    Generator
+	)
+	env.SkipMarkers = true
+	env.RecordPath = filepath.Join(tmp, "generated.yaml")
+	disabled := testConfig(t, masterID, func(in *fetch.Inputs) {
+		in.UserConfig.FluxGitURL, in.UserConfig.FluxGitPrivateKey = nil, nil
+	})
+	for _, g := range All() {
+		if g.Name() == "Flux" {
+			flux = g
+		}
+	}
+
+	// a manifest written before files were recorded is deleted and marked to be skipped
+	manifest := filepath.Join(root, flux.Target())
+	if err = os.MkdirAll(filepath.Dir(manifest), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(manifest, []byte("legacy: true\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// neither a dry run nor rendering without a record deletes it
+	dry, render := *env, *env
+	dry.Dry = true
+	render.RecordPath = ""
+	for _, e := range []*Env{&dry, &render} {
+		if res := Run(context.Background(), e, root, flux, disabled); res.Status != StatusDisabled || res.Err != nil {
+			t.Fatalf("got status %s and error %v", res.Status, res.Err)
+		}
+		if _, err = os.Stat(manifest); err != nil {
+			t.Errorf("%s was deleted (dry %t, record '%s'): %v", manifest, e.Dry, e.RecordPath, err)
+		}
+		if _, err = os.Stat(manifest + skipSuffix); !os.IsNotExist(err) {
+			t.Errorf("%s was marked to be skipped (dry %t, record '%s')", manifest, e.Dry, e.RecordPath)
+		}
+	}
+
+	if res := Run(context.Background(), env, root, flux, disabled); res.Status != StatusDisabled || res.Err != nil {
+		t.Fatalf("got status %s and error %v", res.Status, res.Err)
+	}
+	if _, err = os.Stat(manifest); !os.IsNotExist(err) {
+		t.Errorf("%s was not deleted", manifest)
+	}
+	if _, err = os.Stat(manifest + skipSuffix); err != nil {
+		t.Errorf("%s was not marked to be skipped: %v", manifest, err)
+	}
+
+	// enabling the generator again deletes the marker
+	if res := Run(context.Background(), env, root, flux, enabled); res.Status != StatusWritten || res.Err != nil {
+		t.Fatalf("got status %s and error %v", res.Status, res.Err)
+	}
+	if _, err = os.Stat(manifest); err != nil {
+		t.Errorf("%s was not written: %v", manifest, err)
+	}
+	if _, err = os.Stat(manifest + skipSuffix); !os.IsNotExist(err) {
+		t.Errorf("%s was not deleted", manifest+skipSuffix)
+	}
+	if _, err = os.Stat(env.RecordPath); err != nil {
+		t.Errorf("the files were not recorded: %v", err)
+	}
+
+	// disabling it deletes the recorded manifest
+	if res := Run(context.Background(), env, root, flux, disabled); res.Status != StatusDisabled || res.Err != nil {
+		t.Fatalf("got status %s and error %v", res.Status, res.Err)
+	}
+	if _, err = os.Stat(manifest); !os.IsNotExist(err) {
+		t.Errorf("%s was not deleted", manifest)
+	}
+}
+
+func TestAddons(t *testing.T) {
+	cfg := testConfig(t, agentID, nil)
+	cfg.NodeConfig.AddonPolicies = map[string]model.AddonPolicy{
+		"hcloud-fip": model.AddonAllNodes,
+		"flxu":       model.AddonAllNodes,
+		"cert-man":   model.AddonDisabled,
+	}
+
+	if got, want := fmt.Sprint(UnknownAddons(cfg)), "[cert-man flxu]"; got != want {
+		t.Errorf("UnknownAddons() = %s, want %s", got, want)
+	}
+	addons := Addons(cfg)
+	for name, want := range map[string]AddonStatus{
+		"flux":           {Policy: model.AddonMasterOnly},
+		"hcloud-csi":     {Policy: model.AddonMasterOnly},
+		"hcloud-fip":     {Policy: model.AddonAllNodes, Enabled: true},
+		"sealed-secrets": {Policy: model.AddonMasterOnly},
+		"flxu":           {Policy: model.AddonAllNodes, Unknown: true},
+		"cert-man":       {Policy: model.AddonDisabled, Unknown: true},
+	} {
+		if got, ok := addons[name]; !ok || *got != want {
+			t.Errorf("status of %s = %+v, want %+v", name, got, want)
+		}
+	}
+	if len(addons) != 6 {
+		t.Errorf("got %d addons, want 6", len(addons))
+	}
+}
+
+// testConfig generates the config for the server with instanceID from the API mock fixtures, modify changes the inputs before
+func testConfig(t *testing.T, instanceID string, modify func(in *fetch.Inputs)) *model.HCloudK3OSConfig {
+	in, err := fetch.LoadBundle(fixturesDir, instanceID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if modify != nil {
+		modify(in)
+	}
+	cfg, err := fetch.Generate(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cfg
+}
+
+// testEnv returns an Env whose addon loader finds the builtin bundles completed with the upstream stubs as local bundles, they are extracted below tmp
+func testEnv(t *testing.T, tmp string) *Env {
+	log := logrus.New()
+	log.SetOutput(ioutil.Discard)
+
+	localDir := filepath.Join(tmp, "addons")
+	if err := kustomize.Extract(localDir); err != nil {
+		t.Fatal(err)
+	}
+	upstream, _ := readTree(t, upstreamDir)
+	for p, content := range upstream {
+		dest := filepath.Join(localDir, p)
+		if err := os.MkdirAll(filepath.Dir(dest), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(dest, content, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return &Env{
+		Log:        log,
+		Addons:     addon.NewLoader(log, http.DefaultClient, filepath.Join(tmp, "work"), localDir),
+		HTTPClient: http.DefaultClient,
+		Owner:      &Owner{UID: os.Getuid(), GID: os.Getgid()},
+	}
+}
+
+// readTree returns the contents and modes of the regular files below dir by their absolute path relative to dir
+func readTree(t *testing.T, dir string) (map[string][]byte, map[string]os.FileMode) {
+	var (
+		files = map[string][]byte{}
+		modes = map[string]os.FileMode{}
+	)
+	err := filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil || !info.Mode().IsRegular() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		rel = "/" + filepath.ToSlash(rel)
+		if files[rel], err = ioutil.ReadFile(p); err != nil {
+			return err
+		}
+		modes[rel] = info.Mode().Perm()
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files, modes
+}
+
+func sortedKeys(files map[string][]byte) []string {
+	var keys []string
+	for k := range files {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// writeGolden replaces the golden files in dir
+func writeGolden(t *testing.T, dir string, results []byte, files map[string][]byte) {
+	if err := os.RemoveAll(dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, goldenResults), results, 0644); err != nil {
+		t.Fatal(err)
+	}
+	for p, content := range files {
+		dest := filepath.Join(dir, goldenFilesDir, filepath.FromSlash(p))
+		if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(dest, content, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// compareGolden fails if the results or files differ from the golden files in dir
+func compareGolden(t *testing.T, dir string, results []byte, files map[string][]byte) {
+	want, err := ioutil.ReadFile(filepath.Join(dir, goldenResults))
+	if err != nil {
+		t.Fatalf("error reading golden results, run the tests with -update to create them: %v", err)
+	}
+	if !bytes.Equal(results, want) {
+		t.Errorf("results differ from %s\ngot:\n%s\nwant:\n%s", filepath.Join(dir, goldenResults), results, want)
+	}
+	golden, _ := readTree(t, filepath.Join(dir, goldenFilesDir))
+	for _, p := range sortedKeys(golden) {
+		got, ok := files[p]
+		if !ok {
+			t.Errorf("%s was not generated", p)
+			continue
+		}
+		if !bytes.Equal(got, golden[p]) {
+			t.Errorf("%s differs from the golden file\ngot:\n%s\nwant:\n%s", p, got, golden[p])
+		}
+	}
+	for _, p := range sortedKeys(files) {
+		if _, ok := golden[p]; !ok {
+			t.Errorf("%s was generated but has no golden file", p)
+		}
+	}
+}
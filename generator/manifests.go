@@ -0,0 +1,120 @@
+package generator
+
+import (
+	"context"
+	"path"
+	"sort"
+
+	"github.com/shark/hcloud-k3os-configurator/model"
+	"github.com/shark/hcloud-k3os-configurator/template"
+)
+
+// K3sManifestsDir is the directory k3s deploys the addon manifests from
+const K3sManifestsDir = "/var/lib/rancher/k3s/server/manifests"
+
+// manifest is a generator for a k3s manifest which is built from the addon's bundle, the addon policy decides on which nodes it is enabled
+type manifest struct {
+	name string
+	// bundle is the name of the addon's bundle and its manifest, it is also the name of the addon policy
+	bundle string
+	// enabled is true if the addon is configured
+	enabled func(cfg *model.HCloudK3OSConfig) bool
+	input   func(cfg *model.HCloudK3OSConfig) interface{}
+}
+
+func init() {
+	Register(&manifest{
+		name:    "Flux",
+		bundle:  "flux",
+		enabled: func(cfg *model.HCloudK3OSConfig) bool { return cfg.ClusterConfig.FluxConfig != nil },
+		input:   func(cfg *model.HCloudK3OSConfig) interface{} { return cfg.ClusterConfig.FluxConfig },
+	})
+	Register(&manifest{
+		name:   "HCloud CSI",
+		bundle: "hcloud-csi",
+		input:  func(cfg *model.HCloudK3OSConfig) interface{} { return cfg.ClusterConfig.HCloudToken },
+	})
+	Register(&manifest{
+		name:   "HCloud FIP",
+		bundle: "hcloud-fip",
+		input: func(cfg *model.HCloudK3OSConfig) interface{} {
+			return []interface{}{cfg.ClusterConfig.HCloudToken, cfg.NodeConfig.FloatingIPs}
+		},
+	})
+	Register(&manifest{
+		name:    "SealedSecrets",
+		bundle:  "sealed-secrets",
+		enabled: func(cfg *model.HCloudK3OSConfig) bool { return cfg.ClusterConfig.SealedSecretsConfig != nil },
+		input:   func(cfg *model.HCloudK3OSConfig) interface{} { return cfg.ClusterConfig.SealedSecretsConfig },
+	})
+}
+
+func (m *manifest) Name() string { return m.name }
+
+func (m *manifest) Enabled(cfg *model.HCloudK3OSConfig) bool {
+	return cfg.NodeConfig.AddonPolicy(m.bundle).Allows(cfg.NodeConfig.Role) && (m.enabled == nil || m.enabled(cfg))
+}
+
+// Input also contains the addon policy and the bundles from the config, so the manifest is built again when they change
+func (m *manifest) Input(cfg *model.HCloudK3OSConfig) interface{} {
+	return []interface{}{m.input(cfg), cfg.NodeConfig.Role, cfg.NodeConfig.AddonPolicy(m.bundle), cfg.ClusterConfig.AddonBundles}
+}
+
+// AddonStatus is the effective policy of an addon on a node
+type AddonStatus struct {
+	Policy model.AddonPolicy `yaml:"policy"`
+	// Enabled is true if the addon's manifest is generated on the node
+	Enabled bool `yaml:"enabled"`
+	// Unknown is true if a policy is configured for an addon which does not exist, it has no effect
+	Unknown bool `yaml:"unknown,omitempty"`
+}
+
+// Addons returns the status of the addons for cfg by their names, including the unknown addons with a policy
+func Addons(cfg *model.HCloudK3OSConfig) map[string]*AddonStatus {
+	addons := map[string]*AddonStatus{}
+	for _, g := range All() {
+		if m, ok := g.(*manifest); ok {
+			addons[m.bundle] = &AddonStatus{Policy: cfg.NodeConfig.AddonPolicy(m.bundle), Enabled: m.Enabled(cfg)}
+		}
+	}
+	for _, name := range UnknownAddons(cfg) {
+		addons[name] = &AddonStatus{Policy: cfg.NodeConfig.AddonPolicy(name), Unknown: true}
+	}
+	return addons
+}
+
+// UnknownAddons returns the sorted names of the addons with a policy in cfg which do not exist, e.g. because of a typo
+func UnknownAddons(cfg *model.HCloudK3OSConfig) []string {
+	known := map[string]bool{}
+	for _, g := range All() {
+		if m, ok := g.(*manifest); ok {
+			known[m.bundle] = true
+		}
+	}
+	var unknown []string
+	for name := range cfg.NodeConfig.AddonPolicies {
+		if !known[name] {
+			unknown = append(unknown, name)
+		}
+	}
+	sort.Strings(unknown)
+	return unknown
+}
+
+// Render builds the manifest from the addon's bundle, manifests contain secrets
+func (m *manifest) Render(ctx context.Context, env *Env, cfg *model.HCloudK3OSConfig) ([]*File, error) {
+	b, err := env.Addons.Bundle(ctx, m.bundle, cfg.ClusterConfig.AddonBundles)
+	if err != nil {
+		return nil, err
+	}
+	buf, err := b.Build(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return []*File{{Path: m.Target(), Mode: template.SecretMode, Content: buf}}, nil
+}
+
+// Target is the manifest in the k3s manifests directory
+func (m *manifest) Target() string {
+	return path.Join(K3sManifestsDir, m.bundle+".yaml")
+}
@@ -0,0 +1,80 @@
+package generator
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"path"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/shark/hcloud-k3os-configurator/download"
+	"github.com/shark/hcloud-k3os-configurator/model"
+	"github.com/shark/hcloud-k3os-configurator/template"
+)
+
+// ExtraManifestPrefix is the prefix of the file names of the extra manifests in the k3s manifests directory
+const ExtraManifestPrefix = "hcloud-k3os-extra-"
+
+// extraManifests is a generator for the user supplied manifests of master nodes
+type extraManifests struct{}
+
+func init() {
+	Register(&extraManifests{})
+}
+
+func (e *extraManifests) Name() string { return "extra manifests" }
+
+func (e *extraManifests) Enabled(cfg *model.HCloudK3OSConfig) bool {
+	return cfg.NodeConfig.Role == model.RoleMaster
+}
+
+func (e *extraManifests) Input(cfg *model.HCloudK3OSConfig) interface{} {
+	return []interface{}{cfg.NodeConfig.Role, cfg.ClusterConfig.ExtraManifests}
+}
+
+// Render downloads the manifests given by URL and checks that all manifests are valid YAML
+func (e *extraManifests) Render(ctx context.Context, env *Env, cfg *model.HCloudK3OSConfig) ([]*File, error) {
+	var files []*File
+	for _, manifest := range cfg.ClusterConfig.ExtraManifests {
+		buf := []byte(manifest.Content)
+		if len(manifest.URL) > 0 {
+			var err error
+			if buf, err = download.Verified(ctx, env.HTTPClient, manifest.URL, manifest.SHA256); err != nil {
+				return nil, fmt.Errorf("error downloading extra manifest %s: %w", manifest.Name, err)
+			}
+		}
+		if err := validateYAML(buf); err != nil {
+			return nil, fmt.Errorf("extra manifest %s is invalid: %w", manifest.Name, err)
+		}
+		files = append(files, &File{
+			Path:    path.Join(K3sManifestsDir, ExtraManifestPrefix+manifest.Name+".yaml"),
+			Mode:    template.SecretMode,
+			Content: buf,
+		})
+	}
+	return files, nil
+}
+
+// Target matches all extra manifests, so the ones removed from the config are deleted
+func (e *extraManifests) Target() string {
+	return path.Join(K3sManifestsDir, ExtraManifestPrefix+"*.yaml")
+}
+
+// validateYAML checks that all documents in buf are YAML mappings
+func validateYAML(buf []byte) error {
+	dec := yaml.NewDecoder(bytes.NewReader(buf))
+	for i := 0; ; i++ {
+		var doc interface{}
+		err := dec.Decode(&doc)
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("error parsing document %d: %w", i, err)
+		}
+		if _, ok := doc.(map[interface{}]interface{}); !ok && doc != nil {
+			return fmt.Errorf("document %d is not a mapping", i)
+		}
+	}
+}
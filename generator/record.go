@@ -0,0 +1,128 @@
+package generator
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/shark/hcloud-k3os-configurator/atomicfile"
+)
+
+// RecordPath is where the daemon keeps the record of the generated files
+const RecordPath = "/var/lib/hcloud-k3os/generated.yaml"
+
+// record remembers the files written by the generators, so they can be deleted when a generator is disabled
+type record struct {
+	// Files are the paths of the files written by each generator
+	Files map[string][]string `yaml:"files"`
+	// SkipMarkers are the k3s .skip markers created for the files of disabled generators
+	SkipMarkers []string `yaml:"skip_markers"`
+}
+
+// loadRecord reads the record at p, it is empty if p is empty or there is no record yet
+func loadRecord(p string) (*record, error) {
+	rec := &record{Files: map[string][]string{}}
+	if p == "" {
+		return rec, nil
+	}
+	buf, err := ioutil.ReadFile(p)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("error reading record of generated files: %w", err)
+	}
+	if err = yaml.Unmarshal(buf, rec); err != nil {
+		return nil, fmt.Errorf("error unmarshalling record of generated files: %w", err)
+	}
+	if rec.Files == nil {
+		rec.Files = map[string][]string{}
+	}
+	return rec, nil
+}
+
+// save writes the record to p
+func (r *record) save(p string) error {
+	buf, err := yaml.Marshal(r)
+	if err != nil {
+		return fmt.Errorf("error marshalling record of generated files: %w", err)
+	}
+	if err = os.MkdirAll(path.Dir(p), 0755); err != nil {
+		return fmt.Errorf("error creating directory for record of generated files: %w", err)
+	}
+	return atomicfile.Write(p, buf, atomicfile.WithMode(0600))
+}
+
+// written records the files a generator wrote and deletes the .skip markers created for them before
+func (r *record) written(env *Env, name string, paths []string) error {
+	sort.Strings(paths)
+	r.Files[name] = paths
+	var markers []string
+	for _, marker := range r.SkipMarkers {
+		if !contains(paths, marker[:len(marker)-len(skipSuffix)]) {
+			markers = append(markers, marker)
+			continue
+		}
+		if err := os.Remove(marker); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("error deleting %s: %w", marker, err)
+		}
+		env.Log.Infof("Deleted %s, %s is enabled again", marker, name)
+	}
+	r.SkipMarkers = markers
+	return nil
+}
+
+// collect deletes the files of a disabled generator, i.e. the recorded ones and, when the files are recorded and it is no dry run, the
+// ones matching target, which were e.g. written before files were recorded. They are also marked to be skipped by k3s if env.SkipMarkers is set
+func (r *record) collect(env *Env, target string, name string) error {
+	paths := append([]string(nil), r.Files[name]...)
+	if env.RecordPath != "" && !env.Dry {
+		matches, err := filepath.Glob(target)
+		if err != nil {
+			return fmt.Errorf("error listing files matching %s: %w", target, err)
+		}
+		for _, p := range matches {
+			if !contains(paths, p) {
+				paths = append(paths, p)
+			}
+		}
+	}
+	for _, p := range paths {
+		if env.Dry {
+			env.Log.Infof("Dry run, not deleting %s", p)
+			continue
+		}
+		if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("error deleting %s: %w", p, err)
+		}
+		env.Log.Infof("Deleted %s, %s is disabled", p, name)
+		if !env.SkipMarkers {
+			continue
+		}
+		marker := p + skipSuffix
+		if err := atomicfile.Write(marker, nil); err != nil {
+			return fmt.Errorf("error creating %s: %w", marker, err)
+		}
+		if !contains(r.SkipMarkers, marker) {
+			r.SkipMarkers = append(r.SkipMarkers, marker)
+		}
+	}
+	if !env.Dry {
+		delete(r.Files, name)
+	}
+	return nil
+}
+
+// skipSuffix is appended to the name of a manifest to make k3s skip it
+const skipSuffix = ".skip"
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
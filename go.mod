@@ -3,7 +3,7 @@ module github.com/shark/hcloud-k3os-configurator
 go 1.13
 
 require (
-	github.com/avast/retry-go v2.4.3+incompatible
+	github.com/avast/retry-go v3.0.0+incompatible
 	github.com/fsnotify/fsnotify v1.4.9 // indirect
 	github.com/markbates/pkger v0.15.1
 	github.com/mitchellh/mapstructure v1.3.1 // indirect
@@ -17,7 +17,9 @@ require (
 	github.com/spf13/jwalterweatherman v1.1.0 // indirect
 	github.com/spf13/pflag v1.0.5 // indirect
 	github.com/spf13/viper v1.7.0 // indirect
-	golang.org/x/sys v0.0.0-20200523222454-059865788121 // indirect
+	github.com/vishvananda/netlink v1.1.0
+	golang.org/x/sys v0.0.0-20200523222454-059865788121
 	gopkg.in/ini.v1 v1.56.0 // indirect
 	gopkg.in/yaml.v2 v2.3.0
+	sigs.k8s.io/kustomize/api v0.4.1
 )
@@ -2,52 +2,99 @@ package kustomize
 
 import (
 	"fmt"
-	"io"
+	"io/ioutil"
 	"os"
+	"path"
 	"strings"
 
 	"github.com/markbates/pkger"
-	"github.com/markbates/pkger/pkging"
+	"sigs.k8s.io/kustomize/api/filesys"
+	"sigs.k8s.io/kustomize/api/krusty"
 )
 
-// Extract extracts the kustomization resources to a (temporary) directory
+// staticDir is the pkger directory containing the kustomization resources
+const staticDir = "/kustomize/static"
+
+// Extract extracts the kustomization resources to a (temporary) directory, existing files are replaced
 func Extract(dest string) error {
-	return pkger.Walk("/kustomize/static", func(rawPath string, info os.FileInfo, err error) error {
+	return ExtractTo(filesys.MakeFsOnDisk(), dest)
+}
+
+// ExtractTo extracts the kustomization resources to the directory dest of fSys, e.g. an in-memory filesystem, existing files are replaced
+func ExtractTo(fSys filesys.FileSystem, dest string) error {
+	return pkger.Walk(staticDir, func(rawPath string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
 
-		paths := strings.Split(rawPath, ":")
-		if len(paths) != 2 {
-			return fmt.Errorf("path '%s' has invalid format, expected one colon got %d", rawPath, len(paths))
+		rel, err := relPath(rawPath)
+		if err != nil {
+			return err
 		}
-		path := strings.TrimPrefix(paths[1], "/kustomize/static")
-		if len(path) == 0 {
-			return nil
+		if len(rel) == 0 {
+			return fSys.MkdirAll(dest)
 		}
+		to := path.Join(dest, rel)
 
-		if info.IsDir() {
-			return os.Mkdir(dest+path, 0755)
+		switch {
+		case info.Mode()&os.ModeSymlink != 0:
+			return fmt.Errorf("pkged file at '%s' is a symlink", rawPath)
+		case info.IsDir():
+			if err = fSys.MkdirAll(to); err != nil {
+				return fmt.Errorf("error creating destination directory for '%s' at '%s': %w", rawPath, to, err)
+			}
+			return nil
+		case !info.Mode().IsRegular():
+			return fmt.Errorf("pkged file at '%s' is not a regular file", rawPath)
 		}
 
-		var (
-			from pkging.File
-			to   *os.File
-		)
-		if from, err = pkger.Open(rawPath); err != nil {
+		from, err := pkger.Open(rawPath)
+		if err != nil {
 			return fmt.Errorf("error opening pkged file at '%s': %w", rawPath, err)
 		}
 		defer from.Close()
 
-		if to, err = os.OpenFile(dest+path, os.O_RDWR|os.O_CREATE, 0644); err != nil {
-			return fmt.Errorf("error opening destination file for '%s' at '%s': %w", rawPath, dest+path, err)
+		buf, err := ioutil.ReadAll(from)
+		if err != nil {
+			return fmt.Errorf("error reading pkged file at '%s': %w", rawPath, err)
 		}
-		defer to.Close()
-
-		if _, err := io.Copy(to, from); err != nil {
-			return fmt.Errorf("error copying file for '%s' at '%s': %w", rawPath, dest+path, err)
+		// WriteFile truncates, so extracting again over longer files leaves no trailing content
+		if err = fSys.WriteFile(to, buf); err != nil {
+			return fmt.Errorf("error writing destination file for '%s' at '%s': %w", rawPath, to, err)
 		}
-
 		return nil
 	})
 }
+
+// relPath returns the path of a pkger path like module:/kustomize/static/flux/patch.yaml relative to staticDir
+func relPath(rawPath string) (string, error) {
+	paths := strings.Split(rawPath, ":")
+	if len(paths) != 2 {
+		return "", fmt.Errorf("path '%s' has invalid format, expected one colon got %d", rawPath, len(paths)-1)
+	}
+	p := paths[1]
+	if p == staticDir {
+		return "", nil
+	}
+	if !strings.HasPrefix(p, staticDir+"/") {
+		return "", fmt.Errorf("path '%s' is not below '%s'", rawPath, staticDir)
+	}
+	rel := strings.TrimPrefix(p, staticDir+"/")
+	if path.Clean(rel) != rel || path.IsAbs(rel) || rel == ".." || strings.HasPrefix(rel, "../") {
+		return "", fmt.Errorf("path '%s' is not a clean path below '%s'", rawPath, staticDir)
+	}
+	return rel, nil
+}
+
+// Build builds the kustomization in dir of fSys and returns the resulting manifest
+func Build(fSys filesys.FileSystem, dir string) ([]byte, error) {
+	resources, err := krusty.MakeKustomizer(fSys, krusty.MakeDefaultOptions()).Run(dir)
+	if err != nil {
+		return nil, fmt.Errorf("error building kustomization '%s': %w", dir, err)
+	}
+	buf, err := resources.AsYaml()
+	if err != nil {
+		return nil, fmt.Errorf("error marshalling resources of kustomization '%s': %w", dir, err)
+	}
+	return buf, nil
+}
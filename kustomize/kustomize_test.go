@@ -0,0 +1,134 @@
+package kustomize
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"sigs.k8s.io/kustomize/api/filesys"
+)
+
+// bundleFiles are files of the static resources which are in the repository, relative to staticDir
+var bundleFiles = []string{
+	"flux/addon.yaml",
+	"flux/kustomization.yaml",
+	"hcloud-csi/namespace.yaml",
+	"hcloud-fip/config.yaml.tmpl",
+	"sealed-secrets/patch.yaml",
+}
+
+// readStatic reads a file of the static resources from the source tree
+func readStatic(t *testing.T, rel string) []byte {
+	buf, err := ioutil.ReadFile(filepath.Join("static", filepath.FromSlash(rel)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf
+}
+
+func TestRelPath(t *testing.T) {
+	tests := []struct {
+		rawPath string
+		want    string
+		wantErr bool
+	}{
+		{rawPath: "github.com/shark/hcloud-k3os-configurator:/kustomize/static", want: ""},
+		{rawPath: "github.com/shark/hcloud-k3os-configurator:/kustomize/static/flux", want: "flux"},
+		{rawPath: "github.com/shark/hcloud-k3os-configurator:/kustomize/static/flux/patch.yaml.tmpl", want: "flux/patch.yaml.tmpl"},
+		{rawPath: "/kustomize/static/flux", wantErr: true},
+		{rawPath: "a:b:/kustomize/static/flux", wantErr: true},
+		{rawPath: "github.com/shark/hcloud-k3os-configurator:/kustomize/staticx/flux", wantErr: true},
+		{rawPath: "github.com/shark/hcloud-k3os-configurator:/kustomize/other", wantErr: true},
+		{rawPath: "github.com/shark/hcloud-k3os-configurator:/kustomize/static/../../etc/passwd", wantErr: true},
+		{rawPath: "github.com/shark/hcloud-k3os-configurator:/kustomize/static/..", wantErr: true},
+		{rawPath: "github.com/shark/hcloud-k3os-configurator:/kustomize/static/flux/../../x", wantErr: true},
+		{rawPath: "github.com/shark/hcloud-k3os-configurator:/kustomize/static//flux", wantErr: true},
+		{rawPath: "github.com/shark/hcloud-k3os-configurator:/kustomize/static/./flux", wantErr: true},
+		{rawPath: "github.com/shark/hcloud-k3os-configurator:/kustomize/static/flux/", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.rawPath, func(t *testing.T) {
+			got, err := relPath(tt.rawPath)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got '%s'", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("got '%s', want '%s'", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtract(t *testing.T) {
+	dest, err := ioutil.TempDir("", "hcloud-k3os-kustomize-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dest)
+
+	if err = Extract(dest); err != nil {
+		t.Fatalf("error extracting: %v", err)
+	}
+	for _, rel := range bundleFiles {
+		got, err := ioutil.ReadFile(filepath.Join(dest, filepath.FromSlash(rel)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, readStatic(t, rel)) {
+			t.Errorf("%s differs from the source", rel)
+		}
+	}
+
+	// extracting over an existing tree replaces changed files entirely
+	for _, rel := range bundleFiles {
+		p := filepath.Join(dest, filepath.FromSlash(rel))
+		changed := append(readStatic(t, rel), []byte("\ntrailing: content which is longer than the original\n")...)
+		if err = ioutil.WriteFile(p, changed, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = Extract(dest); err != nil {
+		t.Fatalf("error extracting again: %v", err)
+	}
+	for _, rel := range bundleFiles {
+		got, err := ioutil.ReadFile(filepath.Join(dest, filepath.FromSlash(rel)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, readStatic(t, rel)) {
+			t.Errorf("%s was not replaced:\n%s", rel, got)
+		}
+	}
+}
+
+func TestExtractToInMemory(t *testing.T) {
+	fSys := filesys.MakeFsInMemory()
+	for i := 0; i < 2; i++ {
+		if err := ExtractTo(fSys, "/bundles"); err != nil {
+			t.Fatalf("error extracting (run %d): %v", i, err)
+		}
+		for _, rel := range bundleFiles {
+			got, err := fSys.ReadFile("/bundles/" + rel)
+			if err != nil {
+				t.Fatalf("error reading %s (run %d): %v", rel, i, err)
+			}
+			if !bytes.Equal(got, readStatic(t, rel)) {
+				t.Errorf("%s differs from the source (run %d)", rel, i)
+			}
+		}
+		if !fSys.IsDir("/bundles/flux") {
+			t.Errorf("/bundles/flux is not a directory (run %d)", i)
+		}
+	}
+	if fSys.Exists("/kustomize") || fSys.Exists("/static") {
+		t.Error("files were extracted outside of the destination")
+	}
+}
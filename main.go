@@ -2,16 +2,17 @@ package main
 
 import (
 	"os"
+	"strings"
 
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 
+	"github.com/shark/hcloud-k3os-configurator/api"
 	"github.com/shark/hcloud-k3os-configurator/cli"
 	"github.com/shark/hcloud-k3os-configurator/model"
 )
 
 func main() {
-	var err error
 	cfg := &model.RuntimeConfig{
 		Logger: logrus.New(),
 	}
@@ -26,14 +27,33 @@ func main() {
 	}
 	rootCmd.PersistentFlags().BoolVar(&cfg.Dry, "dry", false, "Dry run")
 	rootCmd.PersistentFlags().BoolVar(&cfg.Debug, "debug", false, "Enable debug logging")
+	rootCmd.PersistentFlags().BoolVar(&cfg.MetadataFirst, "metadata-first", os.Getenv("HCLOUD_METADATA_FIRST") == "true", "Get the node's network config from the metadata service and use the API only for cluster-wide lookups [$HCLOUD_METADATA_FIRST]")
+	rootCmd.PersistentFlags().StringVar(&cfg.MetadataBaseURL, "metadata-url", envOr("HCLOUD_METADATA_URL", api.DefaultMetadataBaseURL), "Base URL of the server metadata service [$HCLOUD_METADATA_URL]")
+	rootCmd.PersistentFlags().StringVar(&cfg.APIBaseURL, "api-url", envOr("HCLOUD_ENDPOINT", api.DefaultAPIBaseURL), "Base URL of the Hetzner Cloud API [$HCLOUD_ENDPOINT]")
+	rootCmd.PersistentFlags().DurationVar(&cfg.APITimeout, "api-timeout", api.DefaultTimeout, "Timeout of a single HTTP request to the metadata service or API")
+	rootCmd.PersistentFlags().StringSliceVar(&cfg.CACertFiles, "ca-cert", envList("HCLOUD_CA_CERTS"), "Additional trusted CA certificate (PEM file), may be repeated [$HCLOUD_CA_CERTS]")
 	rootCmd.AddCommand(cli.Daemon(cfg))
 	rootCmd.AddCommand(cli.Backup(cfg))
+	rootCmd.AddCommand(cli.Config(cfg))
 
-	if err = os.MkdirAll("/var/lib/hcloud-k3os/cache", 0755); err != nil {
-		cfg.Logger.WithError(err).Fatal("error creating /var/lib/hcloud-k3os/cache")
+	if err := rootCmd.Execute(); err != nil {
+		cfg.Logger.Errorf("Command returned an error: %v", err)
+		os.Exit(1)
 	}
+}
 
-	if err = rootCmd.Execute(); err != nil {
-		cfg.Logger.Errorf("Command returned an error: %v", err)
+// envOr returns the value of the environment variable key or def if it is unset or empty
+func envOr(key, def string) string {
+	if val := os.Getenv(key); len(val) > 0 {
+		return val
+	}
+	return def
+}
+
+// envList returns the comma-separated values of the environment variable key
+func envList(key string) []string {
+	if val := os.Getenv(key); len(val) > 0 {
+		return strings.Split(val, ",")
 	}
+	return nil
 }
@@ -1,7 +1,9 @@
 package model
 
 import (
+	"fmt"
 	"net"
+	"time"
 
 	"github.com/sirupsen/logrus"
 )
@@ -23,14 +25,153 @@ type HCloudK3OSConfig struct {
 	ClusterConfig *ClusterConfig `yaml:"cluster_config"`
 }
 
+// Redacted returns a copy of the config with all secrets replaced by Redacted
+func (c *HCloudK3OSConfig) Redacted() *HCloudK3OSConfig {
+	redact := func(s string) string {
+		if len(s) == 0 {
+			return s
+		}
+		return Redacted
+	}
+	r := *c
+	if c.ClusterConfig == nil {
+		return &r
+	}
+	clusterCfg := *c.ClusterConfig
+	clusterCfg.HCloudToken = redact(clusterCfg.HCloudToken)
+	clusterCfg.K3OSToken = redact(clusterCfg.K3OSToken)
+	if c.ClusterConfig.BackupConfig != nil {
+		backupCfg := *c.ClusterConfig.BackupConfig
+		backupCfg.Password = redact(backupCfg.Password)
+		backupCfg.SecretAccessKey = redact(backupCfg.SecretAccessKey)
+		clusterCfg.BackupConfig = &backupCfg
+	}
+	if c.ClusterConfig.FluxConfig != nil {
+		fluxCfg := *c.ClusterConfig.FluxConfig
+		fluxCfg.GitPrivateKey = redact(fluxCfg.GitPrivateKey)
+		clusterCfg.FluxConfig = &fluxCfg
+	}
+	if c.ClusterConfig.SealedSecretsConfig != nil {
+		sealedSecretsCfg := *c.ClusterConfig.SealedSecretsConfig
+		sealedSecretsCfg.TLSCert = redact(sealedSecretsCfg.TLSCert)
+		sealedSecretsCfg.TLSKey = redact(sealedSecretsCfg.TLSKey)
+		clusterCfg.SealedSecretsConfig = &sealedSecretsCfg
+	}
+	// inline manifests may contain secrets
+	clusterCfg.ExtraManifests = nil
+	for _, manifest := range c.ClusterConfig.ExtraManifests {
+		m := *manifest
+		m.Content = redact(m.Content)
+		clusterCfg.ExtraManifests = append(clusterCfg.ExtraManifests, &m)
+	}
+	r.ClusterConfig = &clusterCfg
+	return &r
+}
+
 // NodeConfig is the config for this node
 type NodeConfig struct {
 	Name              string
 	Role              Role
-	PublicNetwork     *Network     `yaml:"public_network"`
-	PrivateNetwork    *Network     `yaml:"private_networks"`
-	FloatingIPs       []*IPAddress `yaml:"floating_ips"`
-	SSHAuthorizedKeys []string     `yaml:"ssh_authorized_keys"`
+	PublicNetwork     *Network        `yaml:"public_network"`
+	PrivateNetworks   PrivateNetworks `yaml:"private_networks"`
+	ClusterNetworkID  string          `yaml:"cluster_network_id"`
+	FloatingIPs       []*IPAddress    `yaml:"floating_ips"`
+	SSHAuthorizedKeys []string        `yaml:"ssh_authorized_keys"`
+	// AddonPolicies are the policies of the addons which are configured, the others are master-only
+	AddonPolicies map[string]AddonPolicy `yaml:"addon_policies"`
+}
+
+// AddonPolicy returns the policy of the addon name, it is AddonMasterOnly unless configured otherwise
+func (n *NodeConfig) AddonPolicy(name string) AddonPolicy {
+	if policy, ok := n.AddonPolicies[name]; ok {
+		return policy
+	}
+	return AddonMasterOnly
+}
+
+// AddonPolicy decides on which nodes the manifest of an addon is generated
+type AddonPolicy string
+
+const (
+	// AddonMasterOnly means the manifest is only generated on master nodes
+	AddonMasterOnly AddonPolicy = "master-only"
+
+	// AddonAllNodes means the manifest is generated on all nodes
+	AddonAllNodes AddonPolicy = "all-nodes"
+
+	// AddonDisabled means the manifest is not generated
+	AddonDisabled AddonPolicy = "disabled"
+)
+
+// ParseAddonPolicy returns the AddonPolicy s
+func ParseAddonPolicy(s string) (AddonPolicy, error) {
+	switch policy := AddonPolicy(s); policy {
+	case AddonMasterOnly, AddonAllNodes, AddonDisabled:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("unknown addon policy '%s', expected %s, %s or %s", s, AddonMasterOnly, AddonAllNodes, AddonDisabled)
+	}
+}
+
+// Allows is true if the manifest is generated on a node with role
+func (p AddonPolicy) Allows(role Role) bool {
+	switch p {
+	case AddonAllNodes:
+		return true
+	case AddonMasterOnly:
+		return role == RoleMaster
+	default:
+		return false
+	}
+}
+
+// ClusterNetwork returns the private network used by k3s/flannel, i.e. the one with ClusterNetworkID or the only one
+func (n *NodeConfig) ClusterNetwork() (*PrivateNetwork, error) {
+	for _, privnet := range n.PrivateNetworks {
+		if len(n.ClusterNetworkID) > 0 && privnet.ID == n.ClusterNetworkID {
+			return privnet, nil
+		}
+	}
+	if len(n.ClusterNetworkID) == 0 && len(n.PrivateNetworks) == 1 {
+		return n.PrivateNetworks[0], nil
+	}
+	return nil, fmt.Errorf("no cluster network with ID '%s' among %d private networks", n.ClusterNetworkID, len(n.PrivateNetworks))
+}
+
+// PrivateNetwork is a Hetzner Cloud network the node is attached to
+type PrivateNetwork struct {
+	ID         string `yaml:"id"`
+	Name       string `yaml:"name"`
+	MACAddress string `yaml:"mac_address"`
+	Network    `yaml:",inline"`
+}
+
+// PrivateNetworks is the list of private networks of a node
+type PrivateNetworks []*PrivateNetwork
+
+// UnmarshalYAML also accepts the single private network of configs cached by older versions
+func (p *PrivateNetworks) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var (
+		raw    interface{}
+		list   []*PrivateNetwork
+		legacy PrivateNetwork
+		err    error
+	)
+	if err = unmarshal(&raw); err != nil {
+		return err
+	}
+	if _, ok := raw.(map[interface{}]interface{}); ok {
+		if err = unmarshal(&legacy); err != nil {
+			return err
+		}
+		*p = PrivateNetworks{&legacy}
+		return nil
+	}
+	if err = unmarshal(&list); err != nil {
+		return err
+	}
+	*p = list
+	return nil
 }
 
 // Network represents configuration of a network interface
@@ -40,6 +181,13 @@ type Network struct {
 	GatewayIPv6   net.IP       `yaml:"gateway_ipv6"`
 	IPv4Addresses []*IPAddress `yaml:"ipv4_addresses"`
 	IPv6Addresses []*IPAddress `yaml:"ipv6_addresses"`
+	Routes        []*Route     `yaml:"routes"`
+}
+
+// Route is a route to a destination network via a gateway
+type Route struct {
+	Destination *net.IPNet `yaml:"destination"`
+	Gateway     net.IP     `yaml:"gateway"`
 }
 
 // IPAddress is an IP address on a network interface
@@ -71,6 +219,8 @@ type ClusterConfig struct {
 	BackupConfig        *BackupConfig        `yaml:"backup_config"`
 	FluxConfig          *FluxConfig          `yaml:"flux_config"`
 	SealedSecretsConfig *SealedSecretsConfig `yaml:"sealed_secrets_config"`
+	AddonBundles        []*AddonBundle       `yaml:"addon_bundles"`
+	ExtraManifests      []*ExtraManifest     `yaml:"extra_manifests"`
 }
 
 // BackupConfig is the restic config
@@ -93,9 +243,31 @@ type SealedSecretsConfig struct {
 	TLSKey  string `yaml:"tls_key"`
 }
 
+// AddonBundle is an addon bundle archive (.tar.gz) which is downloaded from URL, it takes precedence over the other bundles of the addon
+type AddonBundle struct {
+	URL string `yaml:"url"`
+	// SHA256 is the hex encoded checksum of the archive
+	SHA256 string `yaml:"sha256"`
+}
+
+// ExtraManifest is a user supplied k3s manifest, its Content is inline or downloaded from URL
+type ExtraManifest struct {
+	Name    string `yaml:"name"`
+	Content string `yaml:"content"`
+	URL     string `yaml:"url"`
+	// SHA256 is the hex encoded checksum of the file at URL
+	SHA256 string `yaml:"sha256"`
+}
+
 // RuntimeConfig is the app config at runtime, i.e. flags, logger etc.
 type RuntimeConfig struct {
 	Dry    bool
 	Debug  bool
 	Logger *logrus.Logger
+
+	MetadataFirst   bool
+	MetadataBaseURL string
+	APIBaseURL      string
+	APITimeout      time.Duration
+	CACertFiles     []string
 }
@@ -0,0 +1,86 @@
+package model
+
+import (
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+// secret is part of every secret value of the test configs
+const secret = "s3cr3t"
+
+// testConfig returns a config with every secret set to a value containing secret
+func testConfig() *HCloudK3OSConfig {
+	return &HCloudK3OSConfig{
+		NodeConfig: &NodeConfig{Name: "node-1", Role: RoleMaster},
+		ClusterConfig: &ClusterConfig{
+			ClusterName: "test",
+			HCloudToken: secret + "-hcloud-token",
+			K3OSToken:   secret + "-k3os-token",
+			BackupConfig: &BackupConfig{
+				Password:        secret + "-password",
+				AccessKeyID:     "access-key-id",
+				SecretAccessKey: secret + "-secret-access-key",
+				RepositoryURL:   "s3:https://s3.example.com/backup",
+			},
+			FluxConfig:          &FluxConfig{GitURL: "git@example.com:cluster.git", GitPrivateKey: secret + "-git-private-key"},
+			SealedSecretsConfig: &SealedSecretsConfig{TLSCert: secret + "-tls-cert", TLSKey: secret + "-tls-key"},
+			ExtraManifests: []*ExtraManifest{
+				{Name: "inline", Content: "password: " + secret + "-manifest"},
+				{Name: "remote", URL: "https://example.com/manifest.yaml", SHA256: "abc"},
+			},
+		},
+	}
+}
+
+func TestRedacted(t *testing.T) {
+	var (
+		cfg      = testConfig()
+		redacted = cfg.Redacted()
+	)
+	tests := []struct {
+		name string
+		got  string
+		want string
+	}{
+		{"hcloud_token", redacted.ClusterConfig.HCloudToken, Redacted},
+		{"k3os_token", redacted.ClusterConfig.K3OSToken, Redacted},
+		{"backup_config.password", redacted.ClusterConfig.BackupConfig.Password, Redacted},
+		{"backup_config.secret_access_key", redacted.ClusterConfig.BackupConfig.SecretAccessKey, Redacted},
+		{"flux_config.git_private_key", redacted.ClusterConfig.FluxConfig.GitPrivateKey, Redacted},
+		{"sealed_secrets_config.tls_cert", redacted.ClusterConfig.SealedSecretsConfig.TLSCert, Redacted},
+		{"sealed_secrets_config.tls_key", redacted.ClusterConfig.SealedSecretsConfig.TLSKey, Redacted},
+		{"inline extra manifest", redacted.ClusterConfig.ExtraManifests[0].Content, Redacted},
+		{"empty extra manifest content", redacted.ClusterConfig.ExtraManifests[1].Content, ""},
+		{"cluster_name", redacted.ClusterConfig.ClusterName, "test"},
+		{"backup_config.access_key_id", redacted.ClusterConfig.BackupConfig.AccessKeyID, "access-key-id"},
+		{"flux_config.git_url", redacted.ClusterConfig.FluxConfig.GitURL, "git@example.com:cluster.git"},
+		{"extra manifest url", redacted.ClusterConfig.ExtraManifests[1].URL, "https://example.com/manifest.yaml"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.got != tt.want {
+				t.Errorf("got '%s', want '%s'", tt.got, tt.want)
+			}
+		})
+	}
+
+	buf, err := yaml.Marshal(redacted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(buf), secret) {
+		t.Errorf("redacted config contains a secret:\n%s", buf)
+	}
+	if !strings.HasPrefix(cfg.ClusterConfig.HCloudToken, secret) || !strings.HasPrefix(cfg.ClusterConfig.ExtraManifests[0].Content, "password: "+secret) {
+		t.Error("the original config was modified")
+	}
+}
+
+func TestRedactedWithoutClusterConfig(t *testing.T) {
+	cfg := &HCloudK3OSConfig{NodeConfig: &NodeConfig{Name: "node-1"}}
+	if redacted := cfg.Redacted(); redacted.ClusterConfig != nil || redacted.NodeConfig.Name != "node-1" {
+		t.Errorf("got %+v", redacted)
+	}
+}
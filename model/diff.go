@@ -0,0 +1,299 @@
+package model
+
+import (
+	"fmt"
+	"net"
+)
+
+// ChangeKind is the kind of a config change
+type ChangeKind string
+
+const (
+	// ChangeAdded means the value was added
+	ChangeAdded ChangeKind = "added"
+
+	// ChangeRemoved means the value was removed
+	ChangeRemoved ChangeKind = "removed"
+
+	// ChangeModified means the value changed
+	ChangeModified ChangeKind = "changed"
+)
+
+// Redacted replaces secret values in changes
+const Redacted = "<redacted>"
+
+// Change is a single difference between two configs
+type Change struct {
+	// Field is the YAML path of the changed field, e.g. node_config.role
+	Field string
+	Kind  ChangeKind
+	Old   string
+	New   string
+}
+
+func (c *Change) String() string {
+	switch c.Kind {
+	case ChangeAdded:
+		return fmt.Sprintf("%s: added %s", c.Field, c.New)
+	case ChangeRemoved:
+		return fmt.Sprintf("%s: removed %s", c.Field, c.Old)
+	default:
+		return fmt.Sprintf("%s: changed from %s to %s", c.Field, c.Old, c.New)
+	}
+}
+
+// differ collects the changes between two configs
+type differ struct {
+	changes []*Change
+}
+
+// Diff returns the changes from old to new, secrets like tokens and keys are redacted
+func Diff(old, new *HCloudK3OSConfig) []*Change {
+	d := &differ{}
+	if old == nil {
+		old = &HCloudK3OSConfig{}
+	}
+	if new == nil {
+		new = &HCloudK3OSConfig{}
+	}
+	d.nodeConfig(old.NodeConfig, new.NodeConfig)
+	d.clusterConfig(old.ClusterConfig, new.ClusterConfig)
+	return d.changes
+}
+
+func (d *differ) add(field string, kind ChangeKind, old, new string) {
+	d.changes = append(d.changes, &Change{Field: field, Kind: kind, Old: old, New: new})
+}
+
+// value records a change of a plain value
+func (d *differ) value(field, old, new string) {
+	if old != new {
+		d.add(field, ChangeModified, old, new)
+	}
+}
+
+// secret records a change of a secret value without revealing it
+func (d *differ) secret(field, old, new string) {
+	switch {
+	case old == new:
+	case len(old) == 0:
+		d.add(field, ChangeAdded, "", Redacted)
+	case len(new) == 0:
+		d.add(field, ChangeRemoved, Redacted, "")
+	default:
+		d.add(field, ChangeModified, Redacted, Redacted)
+	}
+}
+
+// set records the values which were added to or removed from a list
+func (d *differ) set(field string, old, new []string) {
+	for _, v := range old {
+		if !contains(new, v) {
+			d.add(field, ChangeRemoved, v, "")
+		}
+	}
+	for _, v := range new {
+		if !contains(old, v) {
+			d.add(field, ChangeAdded, "", v)
+		}
+	}
+}
+
+// toggle records whether an optional part of the config was enabled or disabled, it returns true if it is enabled in both
+func (d *differ) toggle(field string, old, new bool) bool {
+	switch {
+	case !old && new:
+		d.add(field, ChangeModified, "disabled", "enabled")
+	case old && !new:
+		d.add(field, ChangeModified, "enabled", "disabled")
+	}
+	return old && new
+}
+
+func (d *differ) nodeConfig(old, new *NodeConfig) {
+	if old == nil {
+		old = &NodeConfig{}
+	}
+	if new == nil {
+		new = &NodeConfig{}
+	}
+	d.value("node_config.name", old.Name, new.Name)
+	d.value("node_config.role", string(old.Role), string(new.Role))
+	d.network("node_config.public_network", old.PublicNetwork, new.PublicNetwork)
+	d.privateNetworks(old.PrivateNetworks, new.PrivateNetworks)
+	d.value("node_config.cluster_network_id", old.ClusterNetworkID, new.ClusterNetworkID)
+	d.set("node_config.floating_ips", ipStrings(old.FloatingIPs), ipStrings(new.FloatingIPs))
+	d.set("node_config.ssh_authorized_keys", old.SSHAuthorizedKeys, new.SSHAuthorizedKeys)
+	for name := range old.AddonPolicies {
+		if _, ok := new.AddonPolicies[name]; !ok {
+			d.value("node_config.addon_policies."+name, string(old.AddonPolicy(name)), string(new.AddonPolicy(name)))
+		}
+	}
+	for name := range new.AddonPolicies {
+		d.value("node_config.addon_policies."+name, string(old.AddonPolicy(name)), string(new.AddonPolicy(name)))
+	}
+}
+
+func (d *differ) network(field string, old, new *Network) {
+	if old == nil {
+		old = &Network{}
+	}
+	if new == nil {
+		new = &Network{}
+	}
+	d.value(field+".net_device_name", old.NetDeviceName, new.NetDeviceName)
+	d.value(field+".gateway_ipv4", ipString(old.GatewayIPv4), ipString(new.GatewayIPv4))
+	d.value(field+".gateway_ipv6", ipString(old.GatewayIPv6), ipString(new.GatewayIPv6))
+	d.set(field+".ipv4_addresses", ipStrings(old.IPv4Addresses), ipStrings(new.IPv4Addresses))
+	d.set(field+".ipv6_addresses", ipStrings(old.IPv6Addresses), ipStrings(new.IPv6Addresses))
+	d.set(field+".routes", routeStrings(old.Routes), routeStrings(new.Routes))
+}
+
+func (d *differ) privateNetworks(old, new PrivateNetworks) {
+	for _, o := range old {
+		if n := findPrivateNetwork(new, o.ID); n != nil {
+			field := fmt.Sprintf("node_config.private_networks[%s]", o.ID)
+			d.value(field+".mac_address", o.MACAddress, n.MACAddress)
+			d.network(field, &o.Network, &n.Network)
+		} else {
+			d.add("node_config.private_networks", ChangeRemoved, privateNetworkString(o), "")
+		}
+	}
+	for _, n := range new {
+		if findPrivateNetwork(old, n.ID) == nil {
+			d.add("node_config.private_networks", ChangeAdded, "", privateNetworkString(n))
+		}
+	}
+}
+
+func (d *differ) clusterConfig(old, new *ClusterConfig) {
+	if old == nil {
+		old = &ClusterConfig{}
+	}
+	if new == nil {
+		new = &ClusterConfig{}
+	}
+	d.value("cluster_config.bootstrap", fmt.Sprint(old.Bootstrap), fmt.Sprint(new.Bootstrap))
+	d.value("cluster_config.cluster_name", old.ClusterName, new.ClusterName)
+	d.secret("cluster_config.hcloud_token", old.HCloudToken, new.HCloudToken)
+	d.secret("cluster_config.k3os_token", old.K3OSToken, new.K3OSToken)
+	d.value("cluster_config.k3os_master_join_url", old.K3OSMasterJoinURL, new.K3OSMasterJoinURL)
+
+	if d.toggle("cluster_config.backup_config", old.BackupConfig != nil, new.BackupConfig != nil) {
+		d.secret("cluster_config.backup_config.password", old.BackupConfig.Password, new.BackupConfig.Password)
+		d.value("cluster_config.backup_config.access_key_id", old.BackupConfig.AccessKeyID, new.BackupConfig.AccessKeyID)
+		d.secret("cluster_config.backup_config.secret_access_key", old.BackupConfig.SecretAccessKey, new.BackupConfig.SecretAccessKey)
+		d.value("cluster_config.backup_config.repository_url", old.BackupConfig.RepositoryURL, new.BackupConfig.RepositoryURL)
+	}
+	if d.toggle("cluster_config.flux_config", old.FluxConfig != nil, new.FluxConfig != nil) {
+		d.value("cluster_config.flux_config.git_url", old.FluxConfig.GitURL, new.FluxConfig.GitURL)
+		d.secret("cluster_config.flux_config.git_private_key", old.FluxConfig.GitPrivateKey, new.FluxConfig.GitPrivateKey)
+	}
+	if d.toggle("cluster_config.sealed_secrets_config", old.SealedSecretsConfig != nil, new.SealedSecretsConfig != nil) {
+		d.secret("cluster_config.sealed_secrets_config.tls_cert", old.SealedSecretsConfig.TLSCert, new.SealedSecretsConfig.TLSCert)
+		d.secret("cluster_config.sealed_secrets_config.tls_key", old.SealedSecretsConfig.TLSKey, new.SealedSecretsConfig.TLSKey)
+	}
+	d.set("cluster_config.addon_bundles", addonBundleStrings(old.AddonBundles), addonBundleStrings(new.AddonBundles))
+	d.extraManifests("cluster_config.extra_manifests", old.ExtraManifests, new.ExtraManifests)
+}
+
+// extraManifests records the extra manifests which were added, removed or changed, inline manifests may contain secrets so only whether they changed is recorded
+func (d *differ) extraManifests(field string, old, new []*ExtraManifest) {
+	for _, manifest := range old {
+		if findExtraManifest(new, manifest.Name) == nil {
+			d.add(field, ChangeRemoved, extraManifestString(manifest), "")
+		}
+	}
+	for _, manifest := range new {
+		prev := findExtraManifest(old, manifest.Name)
+		switch {
+		case prev == nil:
+			d.add(field, ChangeAdded, "", extraManifestString(manifest))
+		case extraManifestString(prev) != extraManifestString(manifest):
+			d.add(field, ChangeModified, extraManifestString(prev), extraManifestString(manifest))
+		case prev.Content != manifest.Content:
+			d.add(field, ChangeModified, extraManifestString(prev), manifest.Name+": inline (changed)")
+		}
+	}
+}
+
+func findPrivateNetwork(privnets PrivateNetworks, id string) *PrivateNetwork {
+	for _, privnet := range privnets {
+		if privnet.ID == id {
+			return privnet
+		}
+	}
+	return nil
+}
+
+func privateNetworkString(privnet *PrivateNetwork) string {
+	s := privnet.ID
+	if len(privnet.Name) > 0 {
+		s += " (" + privnet.Name + ")"
+	}
+	for _, ip := range privnet.IPv4Addresses {
+		s += " " + ip.Net.String()
+	}
+	return s
+}
+
+func ipString(ip net.IP) string {
+	if ip == nil {
+		return ""
+	}
+	return ip.String()
+}
+
+func ipStrings(ips []*IPAddress) []string {
+	var s []string
+	for _, ip := range ips {
+		if ip != nil && ip.Net != nil {
+			s = append(s, ip.Net.String())
+		}
+	}
+	return s
+}
+
+func routeStrings(routes []*Route) []string {
+	var s []string
+	for _, route := range routes {
+		if route != nil && route.Destination != nil {
+			s = append(s, fmt.Sprintf("%s via %s", route.Destination, ipString(route.Gateway)))
+		}
+	}
+	return s
+}
+
+func addonBundleStrings(bundles []*AddonBundle) []string {
+	var s []string
+	for _, bundle := range bundles {
+		s = append(s, fmt.Sprintf("%s (sha256 %s)", bundle.URL, bundle.SHA256))
+	}
+	return s
+}
+
+func findExtraManifest(manifests []*ExtraManifest, name string) *ExtraManifest {
+	for _, manifest := range manifests {
+		if manifest.Name == name {
+			return manifest
+		}
+	}
+	return nil
+}
+
+// extraManifestString describes a manifest by its URL and checksum, inline manifests are not described by their content, which may contain secrets
+func extraManifestString(manifest *ExtraManifest) string {
+	if len(manifest.URL) > 0 {
+		return fmt.Sprintf("%s: %s (sha256 %s)", manifest.Name, manifest.URL, manifest.SHA256)
+	}
+	return manifest.Name + ": inline"
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,109 @@
+package model
+
+import (
+	"strings"
+	"testing"
+)
+
+func changeStrings(changes []*Change) []string {
+	var s []string
+	for _, change := range changes {
+		s = append(s, change.String())
+	}
+	return s
+}
+
+func TestDiff(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(cfg *HCloudK3OSConfig)
+		want   []string
+	}{
+		{
+			name:   "unchanged",
+			modify: func(cfg *HCloudK3OSConfig) {},
+		},
+		{
+			name: "changed secrets are redacted",
+			modify: func(cfg *HCloudK3OSConfig) {
+				cfg.ClusterConfig.HCloudToken = secret + "-new-hcloud-token"
+				cfg.ClusterConfig.BackupConfig.Password = secret + "-new-password"
+				cfg.ClusterConfig.FluxConfig.GitPrivateKey = secret + "-new-git-private-key"
+				cfg.ClusterConfig.SealedSecretsConfig.TLSKey = secret + "-new-tls-key"
+			},
+			want: []string{
+				"cluster_config.hcloud_token: changed from <redacted> to <redacted>",
+				"cluster_config.backup_config.password: changed from <redacted> to <redacted>",
+				"cluster_config.flux_config.git_private_key: changed from <redacted> to <redacted>",
+				"cluster_config.sealed_secrets_config.tls_key: changed from <redacted> to <redacted>",
+			},
+		},
+		{
+			name: "added and removed secrets are redacted",
+			modify: func(cfg *HCloudK3OSConfig) {
+				cfg.ClusterConfig.K3OSToken = ""
+				cfg.ClusterConfig.BackupConfig.SecretAccessKey = ""
+			},
+			want: []string{
+				"cluster_config.k3os_token: removed <redacted>",
+				"cluster_config.backup_config.secret_access_key: removed <redacted>",
+			},
+		},
+		{
+			name: "plain values are shown",
+			modify: func(cfg *HCloudK3OSConfig) {
+				cfg.ClusterConfig.FluxConfig.GitURL = "git@example.com:other.git"
+			},
+			want: []string{"cluster_config.flux_config.git_url: changed from git@example.com:cluster.git to git@example.com:other.git"},
+		},
+		{
+			name: "changed inline manifest",
+			modify: func(cfg *HCloudK3OSConfig) {
+				cfg.ClusterConfig.ExtraManifests[0].Content = "password: " + secret + "-new-manifest"
+			},
+			want: []string{"cluster_config.extra_manifests: changed from inline: inline to inline: inline (changed)"},
+		},
+		{
+			name: "added and removed manifests",
+			modify: func(cfg *HCloudK3OSConfig) {
+				cfg.ClusterConfig.ExtraManifests = []*ExtraManifest{
+					cfg.ClusterConfig.ExtraManifests[1],
+					{Name: "added", Content: "token: " + secret},
+				}
+			},
+			want: []string{
+				"cluster_config.extra_manifests: removed inline: inline",
+				"cluster_config.extra_manifests: added added: inline",
+			},
+		},
+		{
+			name: "inline manifest replaced by URL",
+			modify: func(cfg *HCloudK3OSConfig) {
+				cfg.ClusterConfig.ExtraManifests[0] = &ExtraManifest{Name: "inline", URL: "https://example.com/inline.yaml", SHA256: "def"}
+			},
+			want: []string{"cluster_config.extra_manifests: changed from inline: inline to inline: https://example.com/inline.yaml (sha256 def)"},
+		},
+		{
+			name: "disabled flux",
+			modify: func(cfg *HCloudK3OSConfig) {
+				cfg.ClusterConfig.FluxConfig = nil
+			},
+			want: []string{"cluster_config.flux_config: changed from enabled to disabled"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			updated := testConfig()
+			tt.modify(updated)
+			got := changeStrings(Diff(testConfig(), updated))
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("got changes\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+			}
+			for _, change := range got {
+				if strings.Contains(change, secret) {
+					t.Errorf("change reveals a secret: %s", change)
+				}
+			}
+		})
+	}
+}
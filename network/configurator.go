@@ -0,0 +1,216 @@
+package network
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	// DefaultDADTimeout is how long Apply waits for IPv6 duplicate address detection by default
+	DefaultDADTimeout = 10 * time.Second
+
+	dadPollInterval = 100 * time.Millisecond
+)
+
+// Configurator applies a desired State to the host through a Backend
+type Configurator struct {
+	backend Backend
+	log     *logrus.Logger
+	dry     bool
+
+	// DADTimeout is how long Apply waits for the IPv6 addresses of a link to finish duplicate address detection
+	DADTimeout time.Duration
+}
+
+// NewConfigurator creates a Configurator, in dry mode changes are only logged
+func NewConfigurator(backend Backend, log *logrus.Logger, dry bool) *Configurator {
+	return &Configurator{
+		backend:    backend,
+		log:        log,
+		dry:        dry,
+		DADTimeout: DefaultDADTimeout,
+	}
+}
+
+// Apply brings up all links of the desired state and reconciles their addresses and routes, only what differs is added or deleted
+func (c *Configurator) Apply(ctx context.Context, desired *State) error {
+	var (
+		errs  []error
+		links = desired.links()
+	)
+	fail := func(err error) {
+		c.log.WithError(err).Error("Error configuring network")
+		errs = append(errs, err)
+	}
+
+	for _, link := range links {
+		if err := c.change(fmt.Sprintf("set link %s up", link), func() error { return c.backend.LinkUp(link) }); err != nil {
+			fail(err)
+		}
+	}
+
+	current, err := c.current(links)
+	if err != nil {
+		return err
+	}
+	changes := Diff(current, desired)
+	if changes.Empty() {
+		if len(errs) > 0 {
+			return applyError(errs)
+		}
+		c.log.Info("Network configuration is up to date")
+		return nil
+	}
+	for _, route := range changes.DeleteRoutes {
+		if err = c.change("delete route "+route.String(), func() error { return c.backend.DeleteRoute(route) }); err != nil {
+			fail(err)
+		}
+	}
+	for _, addr := range changes.DeleteAddresses {
+		if err = c.change("delete address "+addr.String(), func() error { return c.backend.DeleteAddress(addr) }); err != nil {
+			fail(err)
+		}
+	}
+	for _, addr := range changes.AddAddresses {
+		if err = c.change("add address "+addr.String(), func() error { return c.backend.AddAddress(addr) }); err != nil {
+			fail(err)
+		}
+	}
+
+	if !c.dry {
+		for _, link := range links {
+			if hasIPv6Route(desired.Routes, link) {
+				if err = c.waitDAD(ctx, link); err != nil {
+					fail(err)
+				}
+			}
+		}
+		// deleting addresses also deletes the routes using them, so the routes to add are computed again
+		if current, err = c.current(links); err != nil {
+			return err
+		}
+		changes = Diff(current, desired)
+	}
+	for _, route := range changes.AddRoutes {
+		if err = c.change("add route "+route.String(), func() error { return c.backend.AddRoute(route) }); err != nil {
+			fail(err)
+		}
+	}
+
+	if len(errs) > 0 {
+		return applyError(errs)
+	}
+	return nil
+}
+
+// applyError summarizes the errors of the failed network changes
+func applyError(errs []error) error {
+	return fmt.Errorf("%d network changes failed, first error: %w", len(errs), errs[0])
+}
+
+// current returns the addresses and routes of links
+func (c *Configurator) current(links []string) (*State, error) {
+	state := &State{}
+	for _, link := range links {
+		addrs, err := c.backend.Addresses(link)
+		if err != nil {
+			return nil, fmt.Errorf("error listing addresses of %s: %w", link, err)
+		}
+		routes, err := c.backend.Routes(link)
+		if err != nil {
+			return nil, fmt.Errorf("error listing routes of %s: %w", link, err)
+		}
+		state.Addresses = append(state.Addresses, addrs...)
+		state.Routes = append(state.Routes, routes...)
+	}
+	return state, nil
+}
+
+// Reset sets all links whose name starts with prefix down, removes their addresses and sets them up again
+func (c *Configurator) Reset(ctx context.Context, prefix string) error {
+	links, err := c.backend.Links()
+	if err != nil {
+		return fmt.Errorf("error listing links: %w", err)
+	}
+	for _, link := range links {
+		if !strings.HasPrefix(link, prefix) {
+			continue
+		}
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+		if err = c.change(fmt.Sprintf("set link %s down", link), func() error { return c.backend.LinkDown(link) }); err != nil {
+			return err
+		}
+		var addrs []*Address
+		if addrs, err = c.backend.Addresses(link); err != nil {
+			return fmt.Errorf("error listing addresses of %s: %w", link, err)
+		}
+		for _, addr := range addrs {
+			if err = c.change("delete address "+addr.String(), func() error { return c.backend.DeleteAddress(addr) }); err != nil {
+				return err
+			}
+		}
+		if err = c.change(fmt.Sprintf("set link %s up", link), func() error { return c.backend.LinkUp(link) }); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// change logs and runs fn unless in dry mode
+func (c *Configurator) change(desc string, fn func() error) error {
+	if c.dry {
+		c.log.Infof("Dry run, not executing: %s", desc)
+		return nil
+	}
+	c.log.Debugf("Network: %s", desc)
+	if err := fn(); err != nil {
+		return fmt.Errorf("error trying to %s: %w", desc, err)
+	}
+	return nil
+}
+
+// waitDAD waits until no IPv6 address of link is tentative anymore
+func (c *Configurator) waitDAD(ctx context.Context, link string) error {
+	ctx, cancel := context.WithTimeout(ctx, c.DADTimeout)
+	defer cancel()
+	for {
+		addrs, err := c.backend.Addresses(link)
+		if err != nil {
+			return fmt.Errorf("error listing addresses of %s: %w", link, err)
+		}
+		tentative := false
+		for _, addr := range addrs {
+			if !addr.isIPv6() {
+				continue
+			}
+			if addr.DADFailed {
+				return fmt.Errorf("duplicate address detection failed for %s", addr)
+			}
+			tentative = tentative || addr.Tentative
+		}
+		if !tentative {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("error waiting for duplicate address detection on %s: %w", link, ctx.Err())
+		case <-time.After(dadPollInterval):
+		}
+	}
+}
+
+// hasIPv6Route is true if there is an IPv6 route on link
+func hasIPv6Route(routes []*Route, link string) bool {
+	for _, route := range routes {
+		if route.Link == link && route.isIPv6() {
+			return true
+		}
+	}
+	return false
+}
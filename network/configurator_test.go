@@ -0,0 +1,345 @@
+package network
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func init() {
+	dadPollInterval = time.Millisecond
+}
+
+func testLogger() *logrus.Logger {
+	log := logrus.New()
+	log.SetOutput(ioutil.Discard)
+	return log
+}
+
+func mustParseCIDR(s string) *net.IPNet {
+	ip, ipnet, err := net.ParseCIDR(s)
+	if err != nil {
+		panic(err)
+	}
+	ipnet.IP = ip
+	return ipnet
+}
+
+// testState is the desired state of a node with a public IPv4 and IPv6 network on eth0 and a private network on eth1
+func testState() *State {
+	return &State{
+		Addresses: []*Address{
+			{Link: "eth0", Net: mustParseCIDR("1.2.3.4/32")},
+			{Link: "eth0", Net: mustParseCIDR("2a01:4f8::1/64")},
+			{Link: "eth1", Net: mustParseCIDR("10.0.0.2/32")},
+		},
+		Routes: []*Route{
+			{Link: "eth0", Destination: mustParseCIDR("172.31.1.1/32"), Source: net.ParseIP("1.2.3.4")},
+			{Link: "eth0", Destination: defaultIPv4, Gateway: net.ParseIP("172.31.1.1")},
+			{Link: "eth0", Destination: defaultIPv6, Gateway: net.ParseIP("fe80::1")},
+			{Link: "eth1", Destination: mustParseCIDR("10.0.0.1/32")},
+			{Link: "eth1", Destination: mustParseCIDR("10.0.0.0/16"), Gateway: net.ParseIP("10.0.0.1")},
+		},
+	}
+}
+
+func addressStrings(addrs []*Address) []string {
+	var s []string
+	for _, addr := range addrs {
+		s = append(s, addr.String())
+	}
+	return s
+}
+
+func routeStrings(routes []*Route) []string {
+	var s []string
+	for _, route := range routes {
+		s = append(s, route.String())
+	}
+	return s
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestDiff(t *testing.T) {
+	var (
+		pub       = &Address{Link: "eth0", Net: mustParseCIDR("1.2.3.4/32")}
+		stale     = &Address{Link: "eth0", Net: mustParseCIDR("1.2.3.5/32")}
+		linkLocal = &Address{Link: "eth0", Net: mustParseCIDR("fe80::1234/64")}
+		other     = &Address{Link: "docker0", Net: mustParseCIDR("172.17.0.1/16")}
+		def       = &Route{Link: "eth0", Destination: defaultIPv4, Gateway: net.ParseIP("172.31.1.1")}
+		oldDef    = &Route{Link: "eth0", Destination: defaultIPv4, Gateway: net.ParseIP("172.31.1.2"), Owned: true}
+		gw        = &Route{Link: "eth0", Destination: mustParseCIDR("172.31.1.1/32"), Source: net.ParseIP("1.2.3.4")}
+		oldGw     = &Route{Link: "eth0", Destination: mustParseCIDR("172.31.1.1/32"), Source: net.ParseIP("1.2.3.5"), Owned: true}
+		kernel    = &Route{Link: "eth0", Destination: mustParseCIDR("1.2.3.0/24")}
+		llRoute   = &Route{Link: "eth0", Destination: mustParseCIDR("fe80::/64")}
+		raRoute   = &Route{Link: "eth0", Destination: defaultIPv6, Gateway: net.ParseIP("fe80::1")}
+		flannel   = &Route{Link: "eth0", Destination: mustParseCIDR("10.42.1.0/24"), Gateway: net.ParseIP("10.42.1.0")}
+		otherRt   = &Route{Link: "docker0", Destination: mustParseCIDR("172.17.0.0/16"), Owned: true}
+	)
+	tests := []struct {
+		name             string
+		current, desired *State
+		want             *Changes
+	}{
+		{
+			name:    "empty",
+			current: &State{},
+			desired: &State{},
+			want:    &Changes{},
+		},
+		{
+			name:    "up to date",
+			current: &State{Addresses: []*Address{pub}, Routes: []*Route{def}},
+			desired: &State{Addresses: []*Address{pub}, Routes: []*Route{def}},
+			want:    &Changes{},
+		},
+		{
+			name:    "add",
+			current: &State{},
+			desired: &State{Addresses: []*Address{pub}, Routes: []*Route{def}},
+			want:    &Changes{AddAddresses: []*Address{pub}, AddRoutes: []*Route{def}},
+		},
+		{
+			name:    "replace",
+			current: &State{Addresses: []*Address{stale}, Routes: []*Route{oldDef}},
+			desired: &State{Addresses: []*Address{pub}, Routes: []*Route{def}},
+			want: &Changes{
+				AddAddresses:    []*Address{pub},
+				DeleteAddresses: []*Address{stale},
+				AddRoutes:       []*Route{def},
+				DeleteRoutes:    []*Route{oldDef},
+			},
+		},
+		{
+			name:    "changed source",
+			current: &State{Routes: []*Route{oldGw}},
+			desired: &State{Routes: []*Route{gw}},
+			want:    &Changes{AddRoutes: []*Route{gw}, DeleteRoutes: []*Route{oldGw}},
+		},
+		{
+			name:    "kernel and link local are kept",
+			current: &State{Addresses: []*Address{pub, linkLocal}, Routes: []*Route{def, kernel, llRoute}},
+			desired: &State{Addresses: []*Address{pub}, Routes: []*Route{def}},
+			want:    &Changes{},
+		},
+		{
+			name:    "routes of others are kept",
+			current: &State{Routes: []*Route{def, raRoute, flannel}},
+			desired: &State{Routes: []*Route{def}},
+			want:    &Changes{},
+		},
+		{
+			name:    "other links are kept",
+			current: &State{Addresses: []*Address{pub, other}, Routes: []*Route{def, otherRt}},
+			desired: &State{Addresses: []*Address{pub}, Routes: []*Route{def}},
+			want:    &Changes{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Diff(tt.current, tt.desired)
+			if !equalStrings(addressStrings(got.AddAddresses), addressStrings(tt.want.AddAddresses)) {
+				t.Errorf("AddAddresses = %v, want %v", got.AddAddresses, tt.want.AddAddresses)
+			}
+			if !equalStrings(addressStrings(got.DeleteAddresses), addressStrings(tt.want.DeleteAddresses)) {
+				t.Errorf("DeleteAddresses = %v, want %v", got.DeleteAddresses, tt.want.DeleteAddresses)
+			}
+			if !equalStrings(routeStrings(got.AddRoutes), routeStrings(tt.want.AddRoutes)) {
+				t.Errorf("AddRoutes = %v, want %v", got.AddRoutes, tt.want.AddRoutes)
+			}
+			if !equalStrings(routeStrings(got.DeleteRoutes), routeStrings(tt.want.DeleteRoutes)) {
+				t.Errorf("DeleteRoutes = %v, want %v", got.DeleteRoutes, tt.want.DeleteRoutes)
+			}
+			if got.Empty() != tt.want.Empty() {
+				t.Errorf("Empty() = %t, want %t", got.Empty(), tt.want.Empty())
+			}
+		})
+	}
+}
+
+// assertState fails if the links of desired are down or their addresses and routes differ from desired
+func assertState(t *testing.T, c *Configurator, b *FakeBackend, desired *State) {
+	t.Helper()
+	links := desired.links()
+	for _, link := range links {
+		if !b.IsUp(link) {
+			t.Errorf("link %s is down", link)
+		}
+	}
+	current, err := c.current(links)
+	if err != nil {
+		t.Fatalf("error reading state: %v", err)
+	}
+	if changes := Diff(current, desired); !changes.Empty() {
+		t.Errorf("state differs from the desired state: %+v", changes)
+	}
+}
+
+func TestApply(t *testing.T) {
+	var (
+		ctx = context.Background()
+		b   = NewFakeBackend("eth0", "eth1")
+		c   = NewConfigurator(b, testLogger(), false)
+	)
+	b.DADPolls = 2
+	if err := b.AddAddress(&Address{Link: "eth0", Net: mustParseCIDR("1.2.3.5/32")}); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.AddRoute(&Route{Link: "eth0", Destination: defaultIPv4, Gateway: net.ParseIP("172.31.1.2")}); err != nil {
+		t.Fatal(err)
+	}
+	flannel := &Route{Link: "eth1", Destination: mustParseCIDR("10.42.1.0/24"), Gateway: net.ParseIP("10.42.1.0")}
+	if err := b.AddForeignRoute(flannel); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.Apply(ctx, testState()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assertState(t, c, b, testState())
+	if routes, _ := b.Routes("eth1"); !containsRoute(routes, flannel) {
+		t.Errorf("the route %s of flannel was deleted", flannel)
+	}
+
+	// applying the same state again changes nothing, adding an existing address or route would fail
+	if err := c.Apply(ctx, testState()); err != nil {
+		t.Fatalf("unexpected error applying again: %v", err)
+	}
+	assertState(t, c, b, testState())
+}
+
+func TestApplyDry(t *testing.T) {
+	var (
+		b = NewFakeBackend("eth0", "eth1")
+		c = NewConfigurator(b, testLogger(), true)
+	)
+	if err := c.Apply(context.Background(), testState()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if b.IsUp("eth0") || b.IsUp("eth1") {
+		t.Error("dry run set a link up")
+	}
+	current, err := c.current([]string{"eth0", "eth1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(current.Addresses) > 0 || len(current.Routes) > 0 {
+		t.Errorf("dry run changed the state: %+v", current)
+	}
+}
+
+// linkUpFailingBackend is a FakeBackend on which setting links up fails
+type linkUpFailingBackend struct {
+	*FakeBackend
+}
+
+var errLinkUp = errors.New("operation not permitted")
+
+func (b *linkUpFailingBackend) LinkUp(link string) error {
+	return errLinkUp
+}
+
+func TestApplyLinkUpFailure(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("with changes", func(t *testing.T) {
+		b := &linkUpFailingBackend{NewFakeBackend("eth0", "eth1")}
+		c := NewConfigurator(b, testLogger(), false)
+		if err := c.Apply(ctx, testState()); !errors.Is(err, errLinkUp) {
+			t.Fatalf("got error %v, want %v", err, errLinkUp)
+		}
+	})
+
+	t.Run("up to date", func(t *testing.T) {
+		fake := NewFakeBackend("eth0", "eth1")
+		if err := NewConfigurator(fake, testLogger(), false).Apply(ctx, testState()); err != nil {
+			t.Fatal(err)
+		}
+		c := NewConfigurator(&linkUpFailingBackend{fake}, testLogger(), false)
+		if err := c.Apply(ctx, testState()); !errors.Is(err, errLinkUp) {
+			t.Fatalf("got error %v, want %v", err, errLinkUp)
+		}
+	})
+}
+
+// dadFailingBackend is a FakeBackend which reports failed duplicate address detection for all IPv6 addresses
+type dadFailingBackend struct {
+	*FakeBackend
+}
+
+func (b *dadFailingBackend) Addresses(link string) ([]*Address, error) {
+	addrs, err := b.FakeBackend.Addresses(link)
+	for _, addr := range addrs {
+		addr.DADFailed = addr.isIPv6()
+	}
+	return addrs, err
+}
+
+func TestWaitDAD(t *testing.T) {
+	var (
+		ctx  = context.Background()
+		ipv4 = &Address{Link: "eth0", Net: mustParseCIDR("1.2.3.4/32")}
+		ipv6 = &Address{Link: "eth0", Net: mustParseCIDR("2a01:4f8::1/64")}
+	)
+	tests := []struct {
+		name     string
+		dadPolls int
+		failed   bool
+		addrs    []*Address
+		wantErr  bool
+	}{
+		{name: "no addresses", addrs: nil},
+		{name: "only IPv4", dadPolls: 1000, addrs: []*Address{ipv4}},
+		{name: "not tentative", addrs: []*Address{ipv4, ipv6}},
+		{name: "tentative for some polls", dadPolls: 3, addrs: []*Address{ipv4, ipv6}},
+		{name: "timeout", dadPolls: 1000000, addrs: []*Address{ipv6}, wantErr: true},
+		{name: "duplicate", failed: true, addrs: []*Address{ipv6}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := NewFakeBackend("eth0")
+			fake.DADPolls = tt.dadPolls
+			for _, addr := range tt.addrs {
+				if err := fake.AddAddress(addr); err != nil {
+					t.Fatal(err)
+				}
+			}
+			var b Backend = fake
+			if tt.failed {
+				b = &dadFailingBackend{fake}
+			}
+			c := NewConfigurator(b, testLogger(), false)
+			c.DADTimeout = 50 * time.Millisecond
+			err := c.waitDAD(ctx, "eth0")
+			if tt.wantErr && err == nil {
+				t.Fatal("expected an error")
+			}
+			if !tt.wantErr && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+	}
+
+	t.Run("link not found", func(t *testing.T) {
+		c := NewConfigurator(NewFakeBackend(), testLogger(), false)
+		if err := c.waitDAD(ctx, "eth0"); err == nil {
+			t.Fatal("expected an error")
+		}
+	})
+}
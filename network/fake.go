@@ -0,0 +1,203 @@
+package network
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// FakeBackend is an in-memory Backend for tests
+type FakeBackend struct {
+	mu    sync.Mutex
+	links map[string]*fakeLink
+
+	// DADPolls is the number of Addresses calls for which an added IPv6 address stays tentative
+	DADPolls int
+}
+
+type fakeLink struct {
+	up        bool
+	addresses []*Address
+	routes    []*Route
+	// tentative counts the remaining Addresses calls until an address finishes duplicate address detection
+	tentative map[string]int
+}
+
+// NewFakeBackend creates a FakeBackend with the given links, which are down and have no addresses or routes
+func NewFakeBackend(links ...string) *FakeBackend {
+	b := &FakeBackend{links: map[string]*fakeLink{}}
+	for _, link := range links {
+		b.links[link] = &fakeLink{tentative: map[string]int{}}
+	}
+	return b
+}
+
+func (b *FakeBackend) link(name string) (*fakeLink, error) {
+	l, ok := b.links[name]
+	if !ok {
+		return nil, fmt.Errorf("link %s not found", name)
+	}
+	return l, nil
+}
+
+// IsUp is true if link is up
+func (b *FakeBackend) IsUp(link string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	l, ok := b.links[link]
+	return ok && l.up
+}
+
+// Links implements Backend
+func (b *FakeBackend) Links() ([]string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var names []string
+	for name := range b.links {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// LinkUp implements Backend
+func (b *FakeBackend) LinkUp(link string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	l, err := b.link(link)
+	if err != nil {
+		return err
+	}
+	l.up = true
+	return nil
+}
+
+// LinkDown implements Backend
+func (b *FakeBackend) LinkDown(link string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	l, err := b.link(link)
+	if err != nil {
+		return err
+	}
+	l.up = false
+	return nil
+}
+
+// Addresses implements Backend
+func (b *FakeBackend) Addresses(link string) ([]*Address, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	l, err := b.link(link)
+	if err != nil {
+		return nil, err
+	}
+	var addrs []*Address
+	for _, addr := range l.addresses {
+		a := *addr
+		if n := l.tentative[addr.Net.String()]; n > 0 {
+			a.Tentative = true
+			l.tentative[addr.Net.String()] = n - 1
+		}
+		addrs = append(addrs, &a)
+	}
+	return addrs, nil
+}
+
+// AddAddress implements Backend, adding an existing address fails like it does in the kernel
+func (b *FakeBackend) AddAddress(addr *Address) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	l, err := b.link(addr.Link)
+	if err != nil {
+		return err
+	}
+	if containsAddress(l.addresses, addr) {
+		return fmt.Errorf("address %s already exists", addr)
+	}
+	l.addresses = append(l.addresses, addr)
+	if addr.isIPv6() && b.DADPolls > 0 {
+		l.tentative[addr.Net.String()] = b.DADPolls
+	}
+	return nil
+}
+
+// DeleteAddress implements Backend
+func (b *FakeBackend) DeleteAddress(addr *Address) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	l, err := b.link(addr.Link)
+	if err != nil {
+		return err
+	}
+	for i, a := range l.addresses {
+		if a.equal(addr) {
+			l.addresses = append(l.addresses[:i], l.addresses[i+1:]...)
+			delete(l.tentative, addr.Net.String())
+			return nil
+		}
+	}
+	return fmt.Errorf("address %s not found", addr)
+}
+
+// Routes implements Backend
+func (b *FakeBackend) Routes(link string) ([]*Route, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	l, err := b.link(link)
+	if err != nil {
+		return nil, err
+	}
+	return append([]*Route(nil), l.routes...), nil
+}
+
+// AddRoute implements Backend, adding an existing route fails like it does in the kernel. The route is owned by the configurator
+func (b *FakeBackend) AddRoute(route *Route) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	l, err := b.link(route.Link)
+	if err != nil {
+		return err
+	}
+	if containsRoute(l.routes, route) {
+		return fmt.Errorf("route %s already exists", route)
+	}
+	owned := *route
+	owned.Owned = true
+	l.routes = append(l.routes, &owned)
+	return nil
+}
+
+// AddForeignRoute adds a route the configurator does not own, like one created by the kernel or another routing daemon
+func (b *FakeBackend) AddForeignRoute(route *Route) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	l, err := b.link(route.Link)
+	if err != nil {
+		return err
+	}
+	if containsRoute(l.routes, route) {
+		return fmt.Errorf("route %s already exists", route)
+	}
+	foreign := *route
+	foreign.Owned = false
+	l.routes = append(l.routes, &foreign)
+	return nil
+}
+
+// DeleteRoute implements Backend
+func (b *FakeBackend) DeleteRoute(route *Route) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	l, err := b.link(route.Link)
+	if err != nil {
+		return err
+	}
+	for i, r := range l.routes {
+		if r.equal(route) {
+			l.routes = append(l.routes[:i], l.routes[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("route %s not found", route)
+}
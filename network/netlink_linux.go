@@ -0,0 +1,157 @@
+//go:build linux
+// +build linux
+
+package network
+
+import (
+	"fmt"
+
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+// routeProtocol tags the routes created by the configurator, it is not used by the kernel or other routing daemons
+const routeProtocol = 0xa8
+
+// netlinkBackend configures the host network through netlink
+type netlinkBackend struct{}
+
+// NewNetlinkBackend returns the Backend which configures the host network through netlink
+func NewNetlinkBackend() (Backend, error) {
+	return &netlinkBackend{}, nil
+}
+
+func (b *netlinkBackend) Links() ([]string, error) {
+	links, err := netlink.LinkList()
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, link := range links {
+		names = append(names, link.Attrs().Name)
+	}
+	return names, nil
+}
+
+func (b *netlinkBackend) LinkUp(link string) error {
+	l, err := netlink.LinkByName(link)
+	if err != nil {
+		return fmt.Errorf("error getting link %s: %w", link, err)
+	}
+	return netlink.LinkSetUp(l)
+}
+
+func (b *netlinkBackend) LinkDown(link string) error {
+	l, err := netlink.LinkByName(link)
+	if err != nil {
+		return fmt.Errorf("error getting link %s: %w", link, err)
+	}
+	return netlink.LinkSetDown(l)
+}
+
+func (b *netlinkBackend) Addresses(link string) ([]*Address, error) {
+	l, err := netlink.LinkByName(link)
+	if err != nil {
+		return nil, fmt.Errorf("error getting link %s: %w", link, err)
+	}
+	addrs, err := netlink.AddrList(l, netlink.FAMILY_ALL)
+	if err != nil {
+		return nil, err
+	}
+	var result []*Address
+	for _, addr := range addrs {
+		result = append(result, &Address{
+			Link:      link,
+			Net:       addr.IPNet,
+			Tentative: addr.Flags&unix.IFA_F_TENTATIVE != 0,
+			DADFailed: addr.Flags&unix.IFA_F_DADFAILED != 0,
+		})
+	}
+	return result, nil
+}
+
+func (b *netlinkBackend) AddAddress(addr *Address) error {
+	l, err := netlink.LinkByName(addr.Link)
+	if err != nil {
+		return fmt.Errorf("error getting link %s: %w", addr.Link, err)
+	}
+	return netlink.AddrAdd(l, &netlink.Addr{IPNet: addr.Net})
+}
+
+func (b *netlinkBackend) DeleteAddress(addr *Address) error {
+	l, err := netlink.LinkByName(addr.Link)
+	if err != nil {
+		return fmt.Errorf("error getting link %s: %w", addr.Link, err)
+	}
+	return netlink.AddrDel(l, &netlink.Addr{IPNet: addr.Net})
+}
+
+func (b *netlinkBackend) Routes(link string) ([]*Route, error) {
+	l, err := netlink.LinkByName(link)
+	if err != nil {
+		return nil, fmt.Errorf("error getting link %s: %w", link, err)
+	}
+	routes, err := netlink.RouteList(l, netlink.FAMILY_ALL)
+	if err != nil {
+		return nil, err
+	}
+	var result []*Route
+	for _, route := range routes {
+		if len(route.MultiPath) > 0 {
+			continue
+		}
+		dst := route.Dst
+		if dst == nil {
+			// default routes have no destination, the family is given by the gateway
+			if route.Gw == nil {
+				continue
+			}
+			if dst = defaultIPv4; route.Gw.To4() == nil {
+				dst = defaultIPv6
+			}
+		}
+		result = append(result, &Route{
+			Link:        link,
+			Destination: dst,
+			Gateway:     route.Gw,
+			Source:      route.Src,
+			Owned:       route.Protocol == routeProtocol,
+		})
+	}
+	return result, nil
+}
+
+func (b *netlinkBackend) AddRoute(route *Route) error {
+	r, err := netlinkRoute(route)
+	if err != nil {
+		return err
+	}
+	return netlink.RouteAdd(r)
+}
+
+func (b *netlinkBackend) DeleteRoute(route *Route) error {
+	r, err := netlinkRoute(route)
+	if err != nil {
+		return err
+	}
+	return netlink.RouteDel(r)
+}
+
+// netlinkRoute converts a route to its netlink representation
+func netlinkRoute(route *Route) (*netlink.Route, error) {
+	l, err := netlink.LinkByName(route.Link)
+	if err != nil {
+		return nil, fmt.Errorf("error getting link %s: %w", route.Link, err)
+	}
+	r := &netlink.Route{
+		LinkIndex: l.Attrs().Index,
+		Dst:       route.Destination,
+		Gw:        route.Gateway,
+		Src:       route.Source,
+		Protocol:  routeProtocol,
+	}
+	if route.Gateway == nil {
+		r.Scope = netlink.SCOPE_LINK
+	}
+	return r, nil
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+// +build !linux
+
+package network
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// NewNetlinkBackend returns the Backend which configures the host network through netlink, which only exists on Linux
+func NewNetlinkBackend() (Backend, error) {
+	return nil, fmt.Errorf("netlink is not supported on %s", runtime.GOOS)
+}
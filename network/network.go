@@ -0,0 +1,236 @@
+package network
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/shark/hcloud-k3os-configurator/model"
+)
+
+var (
+	defaultIPv4 = &net.IPNet{IP: net.IPv4zero.To4(), Mask: net.CIDRMask(0, 32)}
+	defaultIPv6 = &net.IPNet{IP: net.IPv6zero, Mask: net.CIDRMask(0, 128)}
+)
+
+// Address is an IP address on a network interface
+type Address struct {
+	Link string
+	Net  *net.IPNet
+
+	// Tentative is set while IPv6 duplicate address detection is still running for this address
+	Tentative bool
+	// DADFailed is set if IPv6 duplicate address detection found a duplicate of this address
+	DADFailed bool
+}
+
+// managed is true if the address may be deleted, link local addresses belong to the kernel
+func (a *Address) managed() bool {
+	return !a.Net.IP.IsLinkLocalUnicast()
+}
+
+func (a *Address) String() string {
+	return fmt.Sprintf("%s dev %s", a.Net, a.Link)
+}
+
+// equal is true if a and b are the same address with the same prefix on the same link
+func (a *Address) equal(b *Address) bool {
+	return a.Link == b.Link && a.Net.IP.Equal(b.Net.IP) && a.Net.Mask.String() == b.Net.Mask.String()
+}
+
+// isIPv6 is true for IPv6 addresses
+func (a *Address) isIPv6() bool {
+	return a.Net.IP.To4() == nil
+}
+
+// Route is a route on a network interface, routes without a gateway are link scoped
+type Route struct {
+	Link string
+	// Destination is 0.0.0.0/0 or ::/0 for default routes, never nil
+	Destination *net.IPNet
+	Gateway     net.IP
+	Source      net.IP
+
+	// Owned is set for routes the configurator created, other routes belong to the kernel, router advertisements, DHCP or e.g. flannel
+	Owned bool
+}
+
+// managed is true if the route may be deleted, only the routes the configurator created are
+func (r *Route) managed() bool {
+	return r.Owned
+}
+
+func (r *Route) String() string {
+	s := r.Destination.String()
+	if r.Gateway != nil {
+		s += " via " + r.Gateway.String()
+	}
+	s += " dev " + r.Link
+	if r.Source != nil {
+		s += " src " + r.Source.String()
+	}
+	return s
+}
+
+// equal is true if a and b route the same destination via the same gateway on the same link with the same source
+func (r *Route) equal(b *Route) bool {
+	return r.Link == b.Link && r.Destination.String() == b.Destination.String() && r.Gateway.Equal(b.Gateway) && r.Source.Equal(b.Source)
+}
+
+// isIPv6 is true for IPv6 routes
+func (r *Route) isIPv6() bool {
+	return r.Destination.IP.To4() == nil
+}
+
+// State is a set of addresses and routes, routes are applied in order
+type State struct {
+	Addresses []*Address
+	Routes    []*Route
+}
+
+// Changes are the addresses and routes which have to be added or deleted to get from one state to another
+type Changes struct {
+	AddAddresses    []*Address
+	DeleteAddresses []*Address
+	AddRoutes       []*Route
+	DeleteRoutes    []*Route
+}
+
+// Empty is true if there are no changes
+func (c *Changes) Empty() bool {
+	return len(c.AddAddresses) == 0 && len(c.DeleteAddresses) == 0 && len(c.AddRoutes) == 0 && len(c.DeleteRoutes) == 0
+}
+
+// Diff returns the changes to get from current to desired, only links of desired are changed, addresses belonging to the kernel and routes not created by the configurator are never deleted
+func Diff(current, desired *State) *Changes {
+	var (
+		changes = &Changes{}
+		links   = map[string]bool{}
+	)
+	for _, link := range desired.links() {
+		links[link] = true
+	}
+	for _, addr := range current.Addresses {
+		if links[addr.Link] && addr.managed() && !containsAddress(desired.Addresses, addr) {
+			changes.DeleteAddresses = append(changes.DeleteAddresses, addr)
+		}
+	}
+	for _, addr := range desired.Addresses {
+		if !containsAddress(current.Addresses, addr) {
+			changes.AddAddresses = append(changes.AddAddresses, addr)
+		}
+	}
+	for _, route := range current.Routes {
+		if links[route.Link] && route.managed() && !containsRoute(desired.Routes, route) {
+			changes.DeleteRoutes = append(changes.DeleteRoutes, route)
+		}
+	}
+	for _, route := range desired.Routes {
+		if !containsRoute(current.Routes, route) {
+			changes.AddRoutes = append(changes.AddRoutes, route)
+		}
+	}
+	return changes
+}
+
+func containsAddress(addrs []*Address, addr *Address) bool {
+	for _, a := range addrs {
+		if a.equal(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsRoute(routes []*Route, route *Route) bool {
+	for _, r := range routes {
+		if r.equal(route) {
+			return true
+		}
+	}
+	return false
+}
+
+// links returns the links used by the state in order of appearance
+func (s *State) links() []string {
+	var (
+		links []string
+		seen  = map[string]bool{}
+	)
+	add := func(link string) {
+		if !seen[link] {
+			seen[link] = true
+			links = append(links, link)
+		}
+	}
+	for _, addr := range s.Addresses {
+		add(addr.Link)
+	}
+	for _, route := range s.Routes {
+		add(route.Link)
+	}
+	return links
+}
+
+// Backend reads and changes the network configuration of the host
+type Backend interface {
+	// Links returns the names of all network interfaces
+	Links() ([]string, error)
+	LinkUp(link string) error
+	LinkDown(link string) error
+	Addresses(link string) ([]*Address, error)
+	AddAddress(addr *Address) error
+	DeleteAddress(addr *Address) error
+	Routes(link string) ([]*Route, error)
+	AddRoute(route *Route) error
+	DeleteRoute(route *Route) error
+}
+
+// DesiredState returns the addresses and routes of the node's public and private networks
+func DesiredState(nodeCfg *model.NodeConfig) (*State, error) {
+	var (
+		state  = &State{}
+		pubnet = nodeCfg.PublicNetwork
+	)
+	if pubnet == nil || len(pubnet.IPv4Addresses) == 0 {
+		return nil, fmt.Errorf("public network has no IPv4 address")
+	}
+
+	for _, ip := range pubnet.IPv4Addresses {
+		state.Addresses = append(state.Addresses, &Address{Link: pubnet.NetDeviceName, Net: ip.Net})
+	}
+	state.Routes = append(
+		state.Routes,
+		&Route{Link: pubnet.NetDeviceName, Destination: hostNet(pubnet.GatewayIPv4), Source: pubnet.IPv4Addresses[0].Net.IP},
+		&Route{Link: pubnet.NetDeviceName, Destination: defaultIPv4, Gateway: pubnet.GatewayIPv4},
+	)
+
+	for _, ip := range pubnet.IPv6Addresses {
+		state.Addresses = append(state.Addresses, &Address{Link: pubnet.NetDeviceName, Net: ip.Net})
+	}
+	if len(pubnet.IPv6Addresses) > 0 && pubnet.GatewayIPv6 != nil {
+		state.Routes = append(
+			state.Routes,
+			&Route{Link: pubnet.NetDeviceName, Destination: defaultIPv6, Gateway: pubnet.GatewayIPv6, Source: pubnet.IPv6Addresses[0].Net.IP},
+		)
+	}
+
+	for _, privnet := range nodeCfg.PrivateNetworks {
+		for _, ip := range privnet.IPv4Addresses {
+			state.Addresses = append(state.Addresses, &Address{Link: privnet.NetDeviceName, Net: hostNet(ip.Net.IP)})
+		}
+		state.Routes = append(state.Routes, &Route{Link: privnet.NetDeviceName, Destination: hostNet(privnet.GatewayIPv4)})
+		for _, route := range privnet.Routes {
+			state.Routes = append(state.Routes, &Route{Link: privnet.NetDeviceName, Destination: route.Destination, Gateway: route.Gateway})
+		}
+	}
+
+	return state, nil
+}
+
+// hostNet returns the single host network of ip, i.e. ip/32 or ip/128
+func hostNet(ip net.IP) *net.IPNet {
+	if ip4 := ip.To4(); ip4 != nil {
+		return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}
+}
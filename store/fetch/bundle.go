@@ -0,0 +1,110 @@
+package fetch
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+
+	"github.com/shark/hcloud-k3os-configurator/api"
+	"github.com/shark/hcloud-k3os-configurator/model"
+)
+
+// A bundle is a directory with the responses of the metadata service and the API, laid out like their paths (see test/api-mock/fixtures):
+//
+//	latest/user-data.yml     user data
+//	v1/servers/<id>.json     server of the node and the master
+//	v1/networks/<id>.json    private networks of the node
+//	v1/floating_ips.json     floating IPs of the cluster
+//	v1/_servers.json         result of the search for the cluster's master
+const (
+	bundleUserDataPath    = "latest/user-data.yml"
+	bundleServersDir      = "v1/servers"
+	bundleNetworksDir     = "v1/networks"
+	bundleFloatingIPsPath = "v1/floating_ips.json"
+	bundleMasterListPath  = "v1/_servers.json"
+)
+
+// RunBundle generates the HCloudK3OSConfig for the server with instanceID from a bundle instead of the metadata service and API
+func RunBundle(dir string, instanceID string) (*model.HCloudK3OSConfig, error) {
+	in, err := LoadBundle(dir, instanceID)
+	if err != nil {
+		return nil, err
+	}
+	return Generate(in)
+}
+
+// LoadBundle reads the inputs for the server with instanceID from a bundle
+func LoadBundle(dir string, instanceID string) (*Inputs, error) {
+	var (
+		in  = &Inputs{Networks: map[string]*api.Network{}, Offline: true}
+		buf []byte
+		err error
+	)
+
+	if buf, err = readBundleFile(dir, bundleUserDataPath); err != nil {
+		return nil, err
+	}
+	if in.UserConfig, err = api.ParseUserConfig(buf); err != nil {
+		return nil, fmt.Errorf("error reading user config from user data: %w", err)
+	}
+
+	if in.Server, err = loadBundleServer(dir, instanceID); err != nil {
+		return nil, err
+	}
+
+	for _, assoc := range in.Server.PrivateNetworks {
+		var network *api.Network
+		if buf, err = readBundleFile(dir, filepath.Join(bundleNetworksDir, assoc.ID+".json")); err != nil {
+			return nil, err
+		}
+		if network, err = api.ParseNetwork(buf); err != nil {
+			return nil, fmt.Errorf("error getting network ID %s: %w", assoc.ID, err)
+		}
+		in.Networks[assoc.ID] = network
+	}
+
+	if buf, err = readBundleFile(dir, bundleFloatingIPsPath); err != nil {
+		return nil, err
+	}
+	if in.FloatingIPs, err = api.ParseFloatingIPs(buf); err != nil {
+		return nil, fmt.Errorf("error getting floating IPs: %w", err)
+	}
+
+	var masters []*api.Server
+	if buf, err = readBundleFile(dir, bundleMasterListPath); err != nil {
+		return nil, err
+	}
+	if masters, err = api.ParseServers(buf); err != nil {
+		return nil, fmt.Errorf("error getting master server: %w", err)
+	}
+	if len(masters) != 1 {
+		return nil, fmt.Errorf("could not find a server with role master")
+	}
+	if in.MasterServer, err = loadBundleServer(dir, masters[0].ID); err != nil {
+		return nil, fmt.Errorf("error finding server with role master (ID %s): %w", masters[0].ID, err)
+	}
+
+	return in, nil
+}
+
+// loadBundleServer reads the server with id from a bundle
+func loadBundleServer(dir string, id string) (*api.Server, error) {
+	buf, err := readBundleFile(dir, filepath.Join(bundleServersDir, id+".json"))
+	if err != nil {
+		return nil, err
+	}
+	server, err := api.ParseServer(buf)
+	if err != nil {
+		return nil, fmt.Errorf("error getting server: %w", err)
+	}
+	return server, nil
+}
+
+// readBundleFile reads the file at the relative path name of a bundle
+func readBundleFile(dir string, name string) ([]byte, error) {
+	buf, err := ioutil.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		return nil, fmt.Errorf("error reading bundle file: %w", err)
+	}
+	return buf, nil
+}
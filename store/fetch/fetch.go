@@ -1,9 +1,10 @@
 package fetch
 
 import (
-	"errors"
+	"context"
 	"fmt"
 	"net"
+	"strings"
 	"time"
 
 	"github.com/avast/retry-go"
@@ -13,138 +14,248 @@ import (
 	"github.com/shark/hcloud-k3os-configurator/model"
 )
 
-// Run fetches all necessary resources from the HCloud API and generates the HCloudK3OSConfig
-func Run() (*model.HCloudK3OSConfig, error) {
+// retryOptions returns the options for retrying API calls until ctx is done, waiting as long as a RetryableError hints or backing off otherwise
+func retryOptions(ctx context.Context) []retry.Option {
+	return []retry.Option{
+		retry.Context(ctx),
+		retry.LastErrorOnly(true),
+		retry.Delay(1 * time.Second),
+		retry.DelayType(func(n uint, err error, config *retry.Config) time.Duration {
+			if hint := errorx.RetryAfter(err); hint > 0 {
+				return hint
+			}
+			return retry.BackOffDelay(n, err, config)
+		}),
+	}
+}
+
+// Mode selects where Run gets the node's own network configuration from
+type Mode int
+
+const (
+	// ModeAPI fetches everything from the Hetzner API
+	ModeAPI Mode = iota
+
+	// ModeMetadataFirst fetches the node's network configuration from the server metadata service and uses the API only for labels, private network routes and cluster-wide lookups
+	ModeMetadataFirst
+)
+
+// Run fetches all necessary resources from the HCloud API and generates the HCloudK3OSConfig.
+// In ModeMetadataFirst, the node's networking is built from the metadata service first, if the API lookups fail an *IncompleteError with it is returned
+func Run(ctx context.Context, mode Mode, opts ...api.Option) (*model.HCloudK3OSConfig, error) {
 	// *****
 	// FETCH
 	// *****
 
 	// Fetch UserData
 	var (
-		userConfig *api.UserConfig
-		instanceID string
-		val        string
-		ok         bool
-		err        error
+		metadataClient *api.Client
+		metadata       *api.Metadata
+		networking     *model.HCloudK3OSConfig
+		userConfig     *api.UserConfig
+		instanceID     string
+		err            error
 	)
+	if metadataClient, err = api.NewClient("", opts...); err != nil {
+		return nil, fmt.Errorf("error creating metadata client: %v", err)
+	}
 	if err = retry.Do(func() error {
-		if userConfig, err = api.NewClient("").GetUserConfigFromUserData(); err != nil {
-			if !errors.Is(err, &errorx.RetryableError{}) {
+		if userConfig, err = metadataClient.GetUserConfigFromUserData(ctx); err != nil {
+			if !errorx.IsRetryable(err) {
 				return retry.Unrecoverable(err)
 			}
 			return err
 		}
 		return nil
-	}, retry.Delay(1*time.Second)); err != nil {
-		return nil, fmt.Errorf("error reading user config from user data: %v", err)
+	}, retryOptions(ctx)...); err != nil {
+		return nil, fmt.Errorf("error reading user config from user data: %w", err)
 	}
 
 	// Fetch InstanceID
-	if err = retry.Do(func() error {
-		if instanceID, err = api.NewClient("").GetInstanceID(); err != nil {
-			if !errors.Is(err, &errorx.RetryableError{}) {
+	if mode == ModeMetadataFirst {
+		if metadata, err = fetchMetadata(ctx, metadataClient); err != nil {
+			return nil, err
+		}
+		if networking, err = networkingFromMetadata(metadata, false); err != nil {
+			return nil, err
+		}
+		instanceID = metadata.InstanceID
+	} else if err = retry.Do(func() error {
+		if instanceID, err = metadataClient.GetInstanceID(ctx); err != nil {
+			if !errorx.IsRetryable(err) {
 				return retry.Unrecoverable(err)
 			}
 			return err
 		}
 		return nil
-	}, retry.Delay(1*time.Second)); err != nil {
-		return nil, fmt.Errorf("error getting instance ID: %v", err)
+	}, retryOptions(ctx)...); err != nil {
+		return nil, fmt.Errorf("error getting instance ID: %w", err)
 	}
 
+	// Fetch the resources from the API
+	var (
+		apiClient *api.Client
+		in        = &Inputs{UserConfig: userConfig, Metadata: metadata}
+	)
+	if apiClient, err = api.NewClient(userConfig.HCloudToken, opts...); err != nil {
+		return nil, fmt.Errorf("error creating API client: %v", err)
+	}
+	if err = fetchAPI(ctx, apiClient, instanceID, in); err != nil {
+		if networking != nil {
+			return nil, &IncompleteError{Config: networking, Err: err}
+		}
+		return nil, err
+	}
+	return Generate(in)
+}
+
+// fetchAPI fetches the server, its private networks, the floating IPs and the master server of the cluster from the API into in.
+// With in.Metadata, the private networks are optional because the metadata service has the node's networks, failing to get one is ignored
+func fetchAPI(ctx context.Context, apiClient *api.Client, instanceID string, in *Inputs) error {
 	// Fetch Server
 	var (
-		apiClient   *api.Client
 		server      *api.Server
 		clusterName string
+		ok          bool
+		err         error
 	)
-	{
-		apiClient = api.NewClient(userConfig.HCloudToken)
-	}
 	if err = retry.Do(func() error {
-		if server, err = apiClient.GetServer(instanceID); err != nil {
-			if !errors.Is(err, &errorx.RetryableError{}) {
+		if server, err = apiClient.GetServer(ctx, instanceID); err != nil {
+			if !errorx.IsRetryable(err) {
 				return retry.Unrecoverable(err)
 			}
 			return err
 		}
 		return nil
-	}, retry.Delay(1*time.Second)); err != nil {
-		return nil, fmt.Errorf("error getting server: %v", err)
+	}, retryOptions(ctx)...); err != nil {
+		return fmt.Errorf("error getting server: %w", err)
 	}
 	if clusterName, ok = server.Labels["cluster"]; !ok {
-		return nil, fmt.Errorf("this server does not have a 'cluster' label: %#v", *server)
+		return fmt.Errorf("this server does not have a 'cluster' label: %#v", *server)
 	}
+	in.Server = server
 
-	// Fetch PrivateNetworks
-	networks := make(map[string]*api.Network)
+	// Fetch PrivateNetworks (ModeMetadataFirst only takes the subnets and routes from them)
+	in.Networks = make(map[string]*api.Network)
 	for _, network := range server.PrivateNetworks {
 		var thisNetwork *api.Network
 		if err = retry.Do(func() error {
-			if thisNetwork, err = apiClient.GetNetwork(network.ID); err != nil {
-				if !errors.Is(err, &errorx.RetryableError{}) {
+			if thisNetwork, err = apiClient.GetNetwork(ctx, network.ID); err != nil {
+				if !errorx.IsRetryable(err) {
 					return retry.Unrecoverable(err)
 				}
 				return err
 			}
 			return nil
-		}, retry.Delay(1*time.Second)); err != nil {
-			return nil, fmt.Errorf("error getting network ID %s: %v", network.ID, err)
+		}, retryOptions(ctx)...); err != nil {
+			if in.Metadata != nil {
+				continue
+			}
+			return fmt.Errorf("error getting network ID %s: %w", network.ID, err)
 		}
-		networks[network.ID] = thisNetwork
+		in.Networks[network.ID] = thisNetwork
 	}
 
 	// Fetch FloatingIPs
-	var floatingIPs []*api.FloatingIP
 	if err = retry.Do(func() error {
-		if floatingIPs, err = apiClient.GetFloatingIPsForCluster(clusterName); err != nil {
-			if !errors.Is(err, &errorx.RetryableError{}) {
+		if in.FloatingIPs, err = apiClient.GetFloatingIPsForCluster(ctx, clusterName); err != nil {
+			if !errorx.IsRetryable(err) {
 				return retry.Unrecoverable(err)
 			}
 			return err
 		}
 		return nil
-	}, retry.Delay(1*time.Second)); err != nil {
-		return nil, fmt.Errorf("error getting floating IPs for cluster '%s': %v", clusterName, err)
+	}, retryOptions(ctx)...); err != nil {
+		return fmt.Errorf("error getting floating IPs for cluster '%s': %w", clusterName, err)
 	}
 
 	// Fetch MasterServer
-	var masterServer *api.Server
 	if err = retry.Do(func() error {
-		if masterServer, err = apiClient.GetServerWithRoleInCluster("master", clusterName); err != nil {
-			if !errors.Is(err, &errorx.RetryableError{}) {
+		if in.MasterServer, err = apiClient.GetServerWithRoleInCluster(ctx, "master", clusterName); err != nil {
+			if !errorx.IsRetryable(err) {
 				return retry.Unrecoverable(err)
 			}
 			return err
 		}
 		return nil
-	}, retry.Delay(1*time.Second)); err != nil {
-		return nil, fmt.Errorf("error getting master server for cluster '%s': %v", clusterName, err)
+	}, retryOptions(ctx)...); err != nil {
+		return fmt.Errorf("error getting master server for cluster '%s': %w", clusterName, err)
+	}
+	return nil
+}
+
+// IncompleteError is returned by Run in ModeMetadataFirst if the resources could not be fetched from the API
+type IncompleteError struct {
+	// Config only has the node's networking from the metadata service
+	Config *model.HCloudK3OSConfig
+	Err    error
+}
+
+// Error is the error message
+func (e *IncompleteError) Error() string {
+	return fmt.Sprintf("only the node's networking is available: %s", e.Err)
+}
+
+// Unwrap makes this error conformant with Go 1.13 errors
+func (e *IncompleteError) Unwrap() error {
+	return e.Err
+}
+
+// Inputs are the resources the config is generated from
+type Inputs struct {
+	UserConfig *api.UserConfig
+	// Metadata is only set in ModeMetadataFirst, the node's networks are then taken from it instead of Server and Networks, only the routes are taken from Networks
+	Metadata *api.Metadata
+	Server   *api.Server
+	// Networks are the private networks of Server by ID
+	Networks     map[string]*api.Network
+	FloatingIPs  []*api.FloatingIP
+	MasterServer *api.Server
+
+	// Offline is set if the config is generated for another host, e.g. from a bundle, the local network interfaces are then not looked up
+	Offline bool
+}
+
+// Generate validates the inputs and generates the HCloudK3OSConfig from them
+func Generate(in *Inputs) (*model.HCloudK3OSConfig, error) {
+	var (
+		userConfig   = in.UserConfig
+		metadata     = in.Metadata
+		server       = in.Server
+		networks     = in.Networks
+		floatingIPs  = in.FloatingIPs
+		masterServer = in.MasterServer
+		val          string
+		ok           bool
+		err          error
+	)
+	if _, ok = server.Labels["cluster"]; !ok {
+		return nil, fmt.Errorf("this server does not have a 'cluster' label: %#v", *server)
+	}
+	if masterServer == nil {
+		return nil, fmt.Errorf("no master server")
 	}
 
-	// ********
-	// GENERATE
-	// ********
 	var (
 		cfg = &model.HCloudK3OSConfig{
 			NodeConfig: &model.NodeConfig{
-				PublicNetwork:  &model.Network{},
-				PrivateNetwork: &model.Network{},
-				FloatingIPs:    []*model.IPAddress{},
+				PublicNetwork: &model.Network{},
+				FloatingIPs:   []*model.IPAddress{},
 			},
 			ClusterConfig: &model.ClusterConfig{
 				BackupConfig: &model.BackupConfig{},
 			},
 		}
-		pubipv4net  *net.IPNet
-		pubipv6net  *net.IPNet
-		privipv4net *net.IPNet
-		ipv6gw      net.IP
+		pubipv4net *net.IPNet
+		pubipv6net *net.IPNet
+		ipv6gw     net.IP
 	)
 
 	// NodeConfig
 	if val, ok = server.Labels["node_name"]; ok {
 		cfg.NodeConfig.Name = val
+	} else if metadata != nil && len(metadata.Hostname) > 0 {
+		cfg.NodeConfig.Name = metadata.Hostname
 	} else {
 		return nil, fmt.Errorf("server %v needs a label 'node_name'", server)
 	}
@@ -160,26 +271,33 @@ func Run() (*model.HCloudK3OSConfig, error) {
 		}
 	}
 
-	if pubipv4net, err = server.IPv4Net(); err != nil {
-		return nil, fmt.Errorf("error getting public IPv4Net: %v", err)
-	}
+	if metadata != nil {
+		if cfg.NodeConfig.PublicNetwork, err = publicNetworkFromMetadata(metadata); err != nil {
+			return nil, err
+		}
+	} else {
+		if pubipv4net, err = server.IPv4Net(); err != nil {
+			return nil, fmt.Errorf("error getting public IPv4Net: %v", err)
+		}
+
+		if pubipv6net, err = server.IPv6Net(); err != nil {
+			return nil, fmt.Errorf("error getting public IPv6Net: %v", err)
+		}
 
-	if pubipv6net, err = server.IPv6Net(); err != nil {
-		return nil, fmt.Errorf("error getting public IPv6Net: %v", err)
+		cfg.NodeConfig.PublicNetwork.IPv4Addresses = []*model.IPAddress{{
+			Net:       pubipv4net,
+			IsPrimary: true,
+		}}
+		cfg.NodeConfig.PublicNetwork.IPv6Addresses = []*model.IPAddress{{
+			Net:       pubipv6net,
+			IsPrimary: true,
+		}}
 	}
 
 	if ipv6gw = net.ParseIP("fe80::1"); ipv6gw == nil {
 		return nil, fmt.Errorf("error parsing ipv6gw: %v", err)
 	}
 
-	cfg.NodeConfig.PublicNetwork.IPv4Addresses = []*model.IPAddress{{
-		Net:       pubipv4net,
-		IsPrimary: true,
-	}}
-	cfg.NodeConfig.PublicNetwork.IPv6Addresses = []*model.IPAddress{{
-		Net:       pubipv6net,
-		IsPrimary: true,
-	}}
 	for _, fip := range floatingIPs {
 		var fipnet *net.IPNet
 
@@ -226,33 +344,27 @@ func Run() (*model.HCloudK3OSConfig, error) {
 	cfg.NodeConfig.PublicNetwork.GatewayIPv6 = ipv6gw
 	cfg.NodeConfig.PublicNetwork.NetDeviceName = "eth0"
 
-	if len(server.PrivateNetworks) != 1 {
-		return nil, fmt.Errorf("server doesn't have exactly one private network")
+	if metadata != nil {
+		if cfg.NodeConfig.PrivateNetworks, err = privateNetworksFromMetadata(metadata, networks, in.Offline); err != nil {
+			return nil, err
+		}
+	} else if cfg.NodeConfig.PrivateNetworks, err = privateNetworksFromAPI(server, networks, in.Offline); err != nil {
+		return nil, err
 	}
 
-	var (
-		thisPrivNet     *api.Network
-		thisPrivNetGwv4 net.IP
-	)
-	if thisPrivNet, ok = networks[server.PrivateNetworks[0].ID]; !ok {
-		return nil, fmt.Errorf("did not find cached private network ID '%s'", server.PrivateNetworks[0].ID)
+	if val, ok = server.Labels["k3s_network"]; !ok {
+		val = userConfig.K3SNetwork
 	}
-	if thisPrivNetGwv4 = net.ParseIP(thisPrivNet.GatewayIP); thisPrivNetGwv4 == nil {
-		return nil, fmt.Errorf("unable to parse gateway IP of private network '%s'", thisPrivNet.GatewayIP)
+	if cfg.NodeConfig.ClusterNetworkID, err = clusterNetworkID(cfg.NodeConfig.PrivateNetworks, val); err != nil {
+		return nil, err
 	}
-	if privipv4net, err = server.PrivateNetworks[0].IPv4Net(); err != nil {
-		return nil, fmt.Errorf("error getting public IPv4Net: %v", err)
-	}
-
-	cfg.NodeConfig.PrivateNetwork.IPv4Addresses = []*model.IPAddress{{
-		Net:       privipv4net,
-		IsPrimary: false,
-	}}
-	cfg.NodeConfig.PrivateNetwork.NetDeviceName = "eth1"
-	cfg.NodeConfig.PrivateNetwork.GatewayIPv4 = thisPrivNetGwv4
 
 	cfg.NodeConfig.SSHAuthorizedKeys = userConfig.SSHAuthorizedKeys
 
+	if cfg.NodeConfig.AddonPolicies, err = addonPolicies(server, userConfig); err != nil {
+		return nil, err
+	}
+
 	// ClusterConfig
 	if val, ok = server.Labels["cluster"]; ok {
 		cfg.ClusterConfig.ClusterName = val
@@ -265,10 +377,14 @@ func Run() (*model.HCloudK3OSConfig, error) {
 	cfg.ClusterConfig.BackupConfig.SecretAccessKey = userConfig.BackupSecretAccessKey
 	cfg.ClusterConfig.BackupConfig.RepositoryURL = userConfig.BackupRepositoryURL
 
-	if len(masterServer.PrivateNetworks) != 1 {
-		return nil, fmt.Errorf("master server doesn't have exactly one private network")
+	for _, assoc := range masterServer.PrivateNetworks {
+		if assoc.ID == cfg.NodeConfig.ClusterNetworkID {
+			cfg.ClusterConfig.K3OSMasterJoinURL = fmt.Sprintf("https://%s:6443", assoc.ServerIP)
+		}
+	}
+	if len(cfg.ClusterConfig.K3OSMasterJoinURL) == 0 {
+		return nil, fmt.Errorf("master server isn't attached to the k3s network '%s'", cfg.NodeConfig.ClusterNetworkID)
 	}
-	cfg.ClusterConfig.K3OSMasterJoinURL = fmt.Sprintf("https://%s:6443", masterServer.PrivateNetworks[0].ServerIP)
 
 	if userConfig.FluxGitURL != nil && userConfig.FluxGitPrivateKey != nil {
 		cfg.ClusterConfig.FluxConfig = &model.FluxConfig{
@@ -288,5 +404,161 @@ func Run() (*model.HCloudK3OSConfig, error) {
 		}
 	}
 
+	for _, bundle := range userConfig.AddonBundles {
+		cfg.ClusterConfig.AddonBundles = append(cfg.ClusterConfig.AddonBundles, &model.AddonBundle{
+			URL:    bundle.URL,
+			SHA256: strings.ToLower(bundle.SHA256),
+		})
+	}
+
+	for _, manifest := range userConfig.ExtraManifests {
+		cfg.ClusterConfig.ExtraManifests = append(cfg.ClusterConfig.ExtraManifests, &model.ExtraManifest{
+			Name:    manifest.Name,
+			Content: manifest.Content,
+			URL:     manifest.URL,
+			SHA256:  strings.ToLower(manifest.SHA256),
+		})
+	}
+
 	return cfg, nil
 }
+
+// addonPolicies returns the addon policies from the user config and the server labels 'addon_<name>', which take precedence
+func addonPolicies(server *api.Server, userConfig *api.UserConfig) (map[string]model.AddonPolicy, error) {
+	var (
+		policies = map[string]model.AddonPolicy{}
+		err      error
+	)
+	for name, val := range userConfig.AddonPolicies {
+		if policies[name], err = model.ParseAddonPolicy(val); err != nil {
+			return nil, fmt.Errorf("invalid policy of addon '%s' in user data: %w", name, err)
+		}
+	}
+	for label, val := range server.Labels {
+		if !strings.HasPrefix(label, "addon_") {
+			continue
+		}
+		name := strings.TrimPrefix(label, "addon_")
+		if policies[name], err = model.ParseAddonPolicy(val); err != nil {
+			return nil, fmt.Errorf("invalid policy of addon '%s' in label '%s': %w", name, label, err)
+		}
+	}
+	if len(policies) == 0 {
+		return nil, nil
+	}
+	return policies, nil
+}
+
+// privateNetworksFromAPI generates the private networks config from the server's network associations and the fetched networks
+func privateNetworksFromAPI(server *api.Server, networks map[string]*api.Network, offline bool) (model.PrivateNetworks, error) {
+	var privnets model.PrivateNetworks
+	for i, assoc := range server.PrivateNetworks {
+		var (
+			thisPrivNet     *api.Network
+			thisPrivNetGwv4 net.IP
+			privipv4net     *net.IPNet
+			ok              bool
+			err             error
+		)
+		if thisPrivNet, ok = networks[assoc.ID]; !ok {
+			return nil, fmt.Errorf("did not find cached private network ID '%s'", assoc.ID)
+		}
+		if thisPrivNetGwv4 = net.ParseIP(thisPrivNet.GatewayIP); thisPrivNetGwv4 == nil {
+			return nil, fmt.Errorf("unable to parse gateway IP of private network '%s'", thisPrivNet.GatewayIP)
+		}
+		if privipv4net, err = assoc.IPv4Net(thisPrivNet); err != nil {
+			return nil, fmt.Errorf("error getting private IPv4Net: %v", err)
+		}
+		var routes []*model.Route
+		if routes, err = networkRoutes(thisPrivNet); err != nil {
+			return nil, fmt.Errorf("error getting routes of private network '%s': %v", assoc.ID, err)
+		}
+		privnets = append(privnets, &model.PrivateNetwork{
+			ID:         assoc.ID,
+			Name:       thisPrivNet.Name,
+			MACAddress: assoc.MACAddress,
+			Network: model.Network{
+				// the API lists the networks in attachment order, which is the order of the interfaces after eth0
+				NetDeviceName: netDeviceName(assoc.MACAddress, fmt.Sprintf("eth%d", i+1), offline),
+				GatewayIPv4:   thisPrivNetGwv4,
+				IPv4Addresses: []*model.IPAddress{{
+					Net:       privipv4net,
+					IsPrimary: false,
+				}},
+				Routes: routes,
+			},
+		})
+	}
+	return privnets, nil
+}
+
+// networkRoutes returns a route for every subnet of the network via its gateway and every custom route of the network
+func networkRoutes(network *api.Network) ([]*model.Route, error) {
+	var routes []*model.Route
+	for _, subnet := range network.Subnets {
+		route, err := parseRoute(subnet.IPRange, network.GatewayIP)
+		if err != nil {
+			return nil, fmt.Errorf("invalid subnet: %v", err)
+		}
+		routes = append(routes, route)
+	}
+	for _, r := range network.Routes {
+		route, err := parseRoute(r.Destination, r.GatewayIP)
+		if err != nil {
+			return nil, fmt.Errorf("invalid route: %v", err)
+		}
+		routes = append(routes, route)
+	}
+	return routes, nil
+}
+
+// parseRoute parses a route's destination in CIDR notation and its gateway IP
+func parseRoute(destination string, gateway string) (*model.Route, error) {
+	var (
+		route = &model.Route{}
+		err   error
+	)
+	if _, route.Destination, err = net.ParseCIDR(destination); err != nil {
+		return nil, fmt.Errorf("error parsing destination '%s': %v", destination, err)
+	}
+	if route.Gateway = net.ParseIP(gateway); route.Gateway == nil {
+		return nil, fmt.Errorf("error parsing gateway '%s'", gateway)
+	}
+	return route, nil
+}
+
+// netDeviceName returns the name of the local network interface with the given MAC address or fallback if there is none or the config is generated offline
+func netDeviceName(mac string, fallback string, offline bool) string {
+	if offline {
+		return fallback
+	}
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return fallback
+	}
+	for _, iface := range ifaces {
+		if strings.EqualFold(iface.HardwareAddr.String(), mac) {
+			return iface.Name
+		}
+	}
+	return fallback
+}
+
+// clusterNetworkID selects the private network used by k3s/flannel by ID or name, an empty selector is only valid with a single network
+func clusterNetworkID(privnets model.PrivateNetworks, selector string) (string, error) {
+	if len(privnets) == 0 {
+		return "", fmt.Errorf("server doesn't have a private network")
+	}
+	if len(selector) == 0 {
+		if len(privnets) != 1 {
+			return "", fmt.Errorf("server has %d private networks, select one with the label 'k3s_network' or the user data key 'k3s_network'", len(privnets))
+		}
+		return privnets[0].ID, nil
+	}
+	for _, privnet := range privnets {
+		if privnet.ID == selector || privnet.Name == selector {
+			return privnet.ID, nil
+		}
+	}
+	return "", fmt.Errorf("server isn't attached to the k3s network '%s'", selector)
+}
@@ -0,0 +1,136 @@
+package fetch
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/shark/hcloud-k3os-configurator/api"
+	"github.com/shark/hcloud-k3os-configurator/model"
+)
+
+const fixturesDir = "../../test/api-mock/fixtures"
+
+const testMetadata = `instance-id: "4406144"
+hostname: k3os-server
+public-ipv4: 88.99.36.44
+network-config:
+  config:
+  - name: eth0
+    subnets:
+    - type: dhcp
+    - type: static
+      ipv6: true
+      address: 2a01:4f8:c17:11fc::1/64
+`
+
+const testPrivateNetworks = `- ip: 10.0.0.2
+  alias_ips: []
+  interface_num: 1
+  mac_address: 86:00:00:3f:ac:d9
+  network_id: 50343
+  network_name: test
+  network: 10.0.0.0/16
+  subnet: 10.0.0.0/24
+  gateway: 10.0.0.1
+`
+
+// testServer serves the metadata service and, unless apiDown is set, the API from the fixtures of the master server
+func testServer(apiDown bool) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/latest/user-data":
+			http.ServeFile(w, r, filepath.Join(fixturesDir, "latest", "user-data.yml"))
+		case r.URL.Path == "/hetzner/v1/metadata/instance-id":
+			w.Write([]byte("4406144"))
+		case r.URL.Path == "/hetzner/v1/metadata":
+			w.Write([]byte(testMetadata))
+		case r.URL.Path == "/hetzner/v1/metadata/private-networks":
+			w.Write([]byte(testPrivateNetworks))
+		case apiDown:
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"error": {"code": "unauthorized", "message": "unable to authenticate"}}`))
+		case r.URL.Path == "/v1/servers":
+			w.Header().Set("Content-Type", "application/json")
+			http.ServeFile(w, r, filepath.Join(fixturesDir, "v1", "_servers.json"))
+		case strings.HasPrefix(r.URL.Path, "/v1/"):
+			w.Header().Set("Content-Type", "application/json")
+			http.ServeFile(w, r, filepath.Join(fixturesDir, r.URL.Path+".json"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+}
+
+func testOptions(srv *httptest.Server) []api.Option {
+	return []api.Option{api.WithMetadataBaseURL(srv.URL), api.WithAPIBaseURL(srv.URL + "/v1")}
+}
+
+// routeStrings returns the routes of the first private network of cfg
+func routeStrings(cfg *model.HCloudK3OSConfig) []string {
+	var s []string
+	for _, route := range cfg.NodeConfig.PrivateNetworks[0].Routes {
+		s = append(s, route.Destination.String()+" via "+route.Gateway.String())
+	}
+	return s
+}
+
+func TestRunMetadataFirst(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("API available", func(t *testing.T) {
+		srv := testServer(false)
+		defer srv.Close()
+		cfg, err := Run(ctx, ModeMetadataFirst, testOptions(srv)...)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if cfg.NodeConfig.Name != "server" || cfg.ClusterConfig.ClusterName != "test" {
+			t.Errorf("labels were not used: node name '%s', cluster name '%s'", cfg.NodeConfig.Name, cfg.ClusterConfig.ClusterName)
+		}
+		if got, want := strings.Join(routeStrings(cfg), ","), "10.0.0.0/24 via 10.0.0.1"; got != want {
+			t.Errorf("got routes %s, want the subnets of the network %s", got, want)
+		}
+	})
+
+	t.Run("API unavailable", func(t *testing.T) {
+		srv := testServer(true)
+		defer srv.Close()
+		_, err := Run(ctx, ModeMetadataFirst, testOptions(srv)...)
+		var incomplete *IncompleteError
+		if !errors.As(err, &incomplete) {
+			t.Fatalf("got error %v, want an IncompleteError", err)
+		}
+		if !api.IsAuthError(err) {
+			t.Errorf("the API error is not wrapped: %v", err)
+		}
+		cfg := incomplete.Config
+		if cfg.NodeConfig.Name != "k3os-server" {
+			t.Errorf("got node name '%s', want the hostname", cfg.NodeConfig.Name)
+		}
+		if got, want := cfg.NodeConfig.PublicNetwork.IPv4Addresses[0].Net.String(), "88.99.36.44/32"; got != want {
+			t.Errorf("got public IPv4 %s, want %s", got, want)
+		}
+		if got, want := cfg.NodeConfig.PrivateNetworks[0].IPv4Addresses[0].Net.String(), "10.0.0.2/24"; got != want {
+			t.Errorf("got private IPv4 %s, want %s", got, want)
+		}
+		if got, want := strings.Join(routeStrings(cfg), ","), "10.0.0.0/16 via 10.0.0.1"; got != want {
+			t.Errorf("got routes %s, want the whole network %s", got, want)
+		}
+	})
+
+	t.Run("API mode", func(t *testing.T) {
+		srv := testServer(true)
+		defer srv.Close()
+		_, err := Run(ctx, ModeAPI, testOptions(srv)...)
+		var incomplete *IncompleteError
+		if !api.IsAuthError(err) || errors.As(err, &incomplete) {
+			t.Fatalf("got error %v, want a plain API error", err)
+		}
+	})
+}
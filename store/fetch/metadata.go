@@ -0,0 +1,177 @@
+package fetch
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	"github.com/avast/retry-go"
+
+	"github.com/shark/hcloud-k3os-configurator/api"
+	"github.com/shark/hcloud-k3os-configurator/errorx"
+	"github.com/shark/hcloud-k3os-configurator/model"
+)
+
+// fetchMetadata retrieves the server metadata with retries
+func fetchMetadata(ctx context.Context, metadataClient *api.Client) (*api.Metadata, error) {
+	var (
+		metadata *api.Metadata
+		err      error
+	)
+	if err = retry.Do(func() error {
+		if metadata, err = metadataClient.GetMetadata(ctx); err != nil {
+			if !errorx.IsRetryable(err) {
+				return retry.Unrecoverable(err)
+			}
+			return err
+		}
+		return nil
+	}, retryOptions(ctx)...); err != nil {
+		return nil, fmt.Errorf("error getting metadata: %w", err)
+	}
+	return metadata, nil
+}
+
+// publicNetworkFromMetadata generates the public network config (without floating IPs) from the server metadata
+func publicNetworkFromMetadata(metadata *api.Metadata) (*model.Network, error) {
+	var (
+		server = &api.Server{
+			IPv4Address: metadata.PublicIPv4,
+			IPv6Subnet:  metadata.IPv6Subnet,
+		}
+		pubipv4net *net.IPNet
+		pubipv6net *net.IPNet
+		err        error
+	)
+	if pubipv4net, err = server.IPv4Net(); err != nil {
+		return nil, fmt.Errorf("error getting public IPv4Net: %v", err)
+	}
+	if pubipv6net, err = server.IPv6Net(); err != nil {
+		return nil, fmt.Errorf("error getting public IPv6Net: %v", err)
+	}
+	return &model.Network{
+		NetDeviceName: "eth0",
+		GatewayIPv4:   net.IPv4(172, 31, 1, 1),
+		GatewayIPv6:   net.ParseIP("fe80::1"),
+		IPv4Addresses: []*model.IPAddress{{
+			Net:       pubipv4net,
+			IsPrimary: true,
+		}},
+		IPv6Addresses: []*model.IPAddress{{
+			Net:       pubipv6net,
+			IsPrimary: true,
+		}},
+	}, nil
+}
+
+// privateNetworksFromMetadata generates the private networks config from the server metadata, the routes are taken from networks
+// by ID because the metadata service has neither the single subnets nor the custom routes. Without the network, the whole network range is routed.
+func privateNetworksFromMetadata(metadata *api.Metadata, networks map[string]*api.Network, offline bool) (model.PrivateNetworks, error) {
+	var privnets model.PrivateNetworks
+	for _, privnet := range metadata.PrivateNetworks {
+		var (
+			ip     net.IP
+			subnet *net.IPNet
+			gw     net.IP
+			err    error
+		)
+		if ip = net.ParseIP(privnet.ServerIP); ip == nil {
+			return nil, fmt.Errorf("error parsing private IP '%s'", privnet.ServerIP)
+		}
+		if _, subnet, err = net.ParseCIDR(privnet.Subnet); err != nil {
+			return nil, fmt.Errorf("error parsing subnet '%s' of private network '%s': %v", privnet.Subnet, privnet.NetworkID, err)
+		}
+		if gw = net.ParseIP(privnet.Gateway); gw == nil {
+			return nil, fmt.Errorf("unable to parse gateway IP of private network '%s'", privnet.Gateway)
+		}
+		var routes []*model.Route
+		if network, ok := networks[privnet.NetworkID]; ok {
+			if routes, err = networkRoutes(network); err != nil {
+				return nil, fmt.Errorf("error getting routes of private network '%s': %v", privnet.NetworkID, err)
+			}
+		} else {
+			var route *model.Route
+			if route, err = parseRoute(privnet.Network, privnet.Gateway); err != nil {
+				return nil, fmt.Errorf("error getting route of private network '%s': %v", privnet.NetworkID, err)
+			}
+			routes = []*model.Route{route}
+		}
+		privnets = append(privnets, &model.PrivateNetwork{
+			ID:         privnet.NetworkID,
+			Name:       privnet.NetworkName,
+			MACAddress: privnet.MACAddress,
+			Network: model.Network{
+				NetDeviceName: netDeviceName(privnet.MACAddress, fmt.Sprintf("eth%d", privnet.InterfaceNum), offline),
+				GatewayIPv4:   gw,
+				IPv4Addresses: []*model.IPAddress{{
+					Net:       &net.IPNet{IP: ip, Mask: subnet.Mask},
+					IsPrimary: false,
+				}},
+				Routes: routes,
+			},
+		})
+	}
+	return privnets, nil
+}
+
+// networkingFromMetadata generates a config which only has the node's public and private networks from the server metadata
+func networkingFromMetadata(metadata *api.Metadata, offline bool) (*model.HCloudK3OSConfig, error) {
+	var (
+		cfg = &model.HCloudK3OSConfig{
+			NodeConfig: &model.NodeConfig{
+				Name:        metadata.Hostname,
+				FloatingIPs: []*model.IPAddress{},
+			},
+		}
+		err error
+	)
+	if cfg.NodeConfig.PublicNetwork, err = publicNetworkFromMetadata(metadata); err != nil {
+		return nil, err
+	}
+	if cfg.NodeConfig.PrivateNetworks, err = privateNetworksFromMetadata(metadata, nil, offline); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// RefreshNetworking replaces the node's public and private network config with a fresh one from the server metadata,
+// keeping the floating IPs and the routes of the private networks of cfg. It does not need the Hetzner API.
+func RefreshNetworking(ctx context.Context, cfg *model.HCloudK3OSConfig, opts ...api.Option) error {
+	var (
+		metadataClient *api.Client
+		metadata       *api.Metadata
+		networking     *model.HCloudK3OSConfig
+		err            error
+	)
+	if metadataClient, err = api.NewClient("", opts...); err != nil {
+		return fmt.Errorf("error creating metadata client: %v", err)
+	}
+	if metadata, err = fetchMetadata(ctx, metadataClient); err != nil {
+		return err
+	}
+	if networking, err = networkingFromMetadata(metadata, false); err != nil {
+		return err
+	}
+	var (
+		pubnet   = networking.NodeConfig.PublicNetwork
+		privnets = networking.NodeConfig.PrivateNetworks
+	)
+	// the cached routes were derived from the API and include the single subnets and custom routes
+	for _, privnet := range privnets {
+		for _, cached := range cfg.NodeConfig.PrivateNetworks {
+			if cached.ID == privnet.ID && len(cached.Routes) > 0 {
+				privnet.Routes = cached.Routes
+			}
+		}
+	}
+	for _, fip := range cfg.NodeConfig.FloatingIPs {
+		if fip.Net.IP.To4() != nil {
+			pubnet.IPv4Addresses = append(pubnet.IPv4Addresses, fip)
+		} else {
+			pubnet.IPv6Addresses = append(pubnet.IPv6Addresses, fip)
+		}
+	}
+	cfg.NodeConfig.PublicNetwork = pubnet
+	cfg.NodeConfig.PrivateNetworks = privnets
+	return nil
+}
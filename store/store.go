@@ -1,13 +1,20 @@
 package store
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io/ioutil"
+	"os"
+	"path"
 
 	"github.com/sirupsen/logrus"
 	"gopkg.in/yaml.v2"
 
+	"github.com/shark/hcloud-k3os-configurator/api"
+	"github.com/shark/hcloud-k3os-configurator/atomicfile"
 	"github.com/shark/hcloud-k3os-configurator/cmd"
+	"github.com/shark/hcloud-k3os-configurator/errorx"
 	"github.com/shark/hcloud-k3os-configurator/model"
 	"github.com/shark/hcloud-k3os-configurator/store/fetch"
 )
@@ -15,31 +22,63 @@ import (
 const cachedConfigPath = "/var/lib/hcloud-k3os/config.yaml"
 
 // ConfigureAndLoad tries to fetch & generate the config from scratch and falls back to the cached config if that's not possible
-func ConfigureAndLoad(log *logrus.Logger, dry bool) (cfg *model.HCloudK3OSConfig, err error) {
+func ConfigureAndLoad(ctx context.Context, log *logrus.Logger, dry bool, mode fetch.Mode, opts ...api.Option) (cfg *model.HCloudK3OSConfig, err error) {
 	defer func() {
-		if _, err2 := cmd.Run(&cmd.Command{Name: "dhcpcd", Arg: []string{"--ipv4only", "--noarp", "--release", "eth0"}}, log, dry); err2 != nil {
+		if _, err2 := cmd.Run(ctx, &cmd.Command{Name: "dhcpcd", Arg: []string{"--ipv4only", "--noarp", "--release", "eth0"}}, log, dry); err2 != nil {
 			log.WithError(err2).Error("error releasing eth0 from DHCP")
 			err = fmt.Errorf("error releasing eth0 from DHCP: %w", err2)
 		}
 	}()
 
-	if _, err = cmd.Run(&cmd.Command{Name: "dhcpcd", Arg: []string{"--ipv4only", "--noarp", "eth0"}}, log, dry); err != nil {
+	if _, err = cmd.Run(ctx, &cmd.Command{Name: "dhcpcd", Arg: []string{"--ipv4only", "--noarp", "eth0"}}, log, dry); err != nil {
 		return nil, fmt.Errorf("error configuring eth0 with DHCP: %w", err)
 	}
 
-	return LoadAndCache()
+	return LoadAndCache(ctx, log, mode, opts...)
 }
 
-// LoadAndCache loads the hcloud-k3os config remotely with a fallback on the local cache
-func LoadAndCache() (cfg *model.HCloudK3OSConfig, err error) {
-	if cfg, err = fetch.Run(); err == nil {
+// LoadAndCache loads the hcloud-k3os config remotely with a fallback on the local cache.
+// In fetch.ModeMetadataFirst, the cached config's node networking is refreshed from the metadata service. Without a cached config,
+// the error then is a *fetch.IncompleteError if the node's networking could be built from the metadata service.
+func LoadAndCache(ctx context.Context, log *logrus.Logger, mode fetch.Mode, opts ...api.Option) (cfg *model.HCloudK3OSConfig, err error) {
+	var fetchErr error
+	if cfg, fetchErr = fetch.Run(ctx, mode, opts...); fetchErr == nil {
 		return storeConfig(cfg)
 	}
-	return loadCachedConfig()
+	logFallback(log, fetchErr)
+	if cfg, err = LoadCachedConfig(); err != nil {
+		var incomplete *fetch.IncompleteError
+		if errors.As(fetchErr, &incomplete) {
+			return nil, fmt.Errorf("error loading cached config (%v) and fetching config: %w", err, fetchErr)
+		}
+		return nil, fmt.Errorf("error fetching config (%v) and loading cached config: %w", fetchErr, err)
+	}
+	if mode == fetch.ModeMetadataFirst {
+		if err = fetch.RefreshNetworking(ctx, cfg, opts...); err == nil {
+			return storeConfig(cfg)
+		}
+		log.WithError(err).Warn("Unable to refresh node networking from the metadata service, using cached networking")
+	}
+	return cfg, nil
 }
 
-// loadCachedConfig retrieves the cached config from disk
-func loadCachedConfig() (*model.HCloudK3OSConfig, error) {
+// logFallback explains why fetching the config failed and the cached config is used instead
+func logFallback(log *logrus.Logger, err error) {
+	entry := log.WithError(err)
+	switch {
+	case api.IsAuthError(err):
+		entry.Error("The HCloud token from the user data is invalid or lacks permissions, falling back to cached config")
+	case api.IsErrorCode(err, api.ErrorCodeNotFound):
+		entry.Error("A resource required for the config was not found in the HCloud API, falling back to cached config")
+	case errorx.IsRetryable(err):
+		entry.Warn("The HCloud API or metadata service is temporarily unavailable, falling back to cached config")
+	default:
+		entry.Error("Fetching the config failed, falling back to cached config")
+	}
+}
+
+// LoadCachedConfig retrieves the cached config from disk
+func LoadCachedConfig() (*model.HCloudK3OSConfig, error) {
 	var (
 		buf []byte
 		cfg model.HCloudK3OSConfig
@@ -67,7 +106,11 @@ func storeConfig(cfg *model.HCloudK3OSConfig) (*model.HCloudK3OSConfig, error) {
 		return nil, fmt.Errorf("error marshalling config to YAML: %v", err)
 	}
 
-	if err = ioutil.WriteFile(cachedConfigPath, buf, 0600); err != nil {
+	if err = os.MkdirAll(path.Dir(cachedConfigPath), 0755); err != nil {
+		return nil, fmt.Errorf("error creating directory for cache file at \"%s\": %w", cachedConfigPath, err)
+	}
+
+	if err = atomicfile.Write(cachedConfigPath, buf, atomicfile.WithMode(0600)); err != nil {
 		return nil, fmt.Errorf("error writing info to YAML at \"%s\": %w", cachedConfigPath, err)
 	}
 
@@ -0,0 +1,11 @@
+package template
+
+import "os"
+
+const (
+	// PublicMode is the mode of generated files without secrets
+	PublicMode os.FileMode = 0644
+
+	// SecretMode is the mode of generated files containing tokens or keys
+	SecretMode os.FileMode = 0600
+)
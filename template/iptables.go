@@ -2,7 +2,7 @@ package template
 
 import (
 	"fmt"
-	"os"
+	"io"
 	"text/template"
 
 	"github.com/shark/hcloud-k3os-configurator/model"
@@ -33,8 +33,10 @@ const iptablesTmpl = `*filter
 -A TCP -p tcp --dport 6443 -j ACCEPT
 
 # k3s
--A TCP -s {{ .PrivateNetwork }} -j ACCEPT
--A UDP -s {{ .PrivateNetwork }} -j ACCEPT
+{{- range .PrivateNetworks }}
+-A TCP -s {{ . }} -j ACCEPT
+-A UDP -s {{ . }} -j ACCEPT
+{{- end }}
 
 COMMIT
 `
@@ -78,37 +80,27 @@ COMMIT
 COMMIT
 `
 
-// GenerateIptablesConfig generates the iptables config (IPv4)
-func GenerateIptablesConfig(path string, privnet *model.Network) error {
-	var (
-		f   *os.File
-		err error
-	)
-	t := template.Must(template.New("iptablesConfig").Parse(iptablesTmpl))
-	if f, err = os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644); err != nil {
-		return fmt.Errorf("error opening output file at \"%s\": %w", path, err)
+// WriteIptablesConfig writes the iptables config (IPv4) to w
+func WriteIptablesConfig(w io.Writer, privnets model.PrivateNetworks) error {
+	var networks []string
+	for _, privnet := range privnets {
+		for _, ip := range privnet.IPv4Addresses {
+			networks = append(networks, ip.Net.String())
+		}
 	}
-	defer f.Close()
-	if err = t.Execute(f, struct {
-		PrivateNetwork string
-	}{privnet.IPv4Addresses[0].Net.String()}); err != nil {
+	t := template.Must(template.New("iptablesConfig").Parse(iptablesTmpl))
+	if err := t.Execute(w, struct {
+		PrivateNetworks []string
+	}{networks}); err != nil {
 		return fmt.Errorf("error executing template: %w", err)
 	}
 	return nil
 }
 
-// GenerateIP6tablesConfig generates the ip6tables config (IPv6)
-func GenerateIP6tablesConfig(path string) error {
-	var (
-		f   *os.File
-		err error
-	)
+// WriteIP6tablesConfig writes the ip6tables config (IPv6) to w
+func WriteIP6tablesConfig(w io.Writer) error {
 	t := template.Must(template.New("ip6tablesConfig").Parse(ip6tablesTmpl))
-	if f, err = os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644); err != nil {
-		return fmt.Errorf("error opening output file at \"%s\": %w", path, err)
-	}
-	defer f.Close()
-	if err = t.Execute(f, nil); err != nil {
+	if err := t.Execute(w, nil); err != nil {
 		return fmt.Errorf("error executing template: %w", err)
 	}
 	return nil
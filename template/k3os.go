@@ -2,15 +2,15 @@ package template
 
 import (
 	"fmt"
-	"os"
+	"io"
 
 	"gopkg.in/yaml.v2"
 
 	"github.com/shark/hcloud-k3os-configurator/model"
 )
 
-// GenerateK3OSConfig generates the config for k3os
-func GenerateK3OSConfig(path string, cfg *model.HCloudK3OSConfig) (err error) {
+// WriteK3OSConfig writes the config for k3os to w
+func WriteK3OSConfig(w io.Writer, cfg *model.HCloudK3OSConfig) (err error) {
 	type k3osCfg struct {
 		SSHAuthorizedKeys []string `yaml:"ssh_authorized_keys"`
 		K3OS              struct {
@@ -20,10 +20,13 @@ func GenerateK3OSConfig(path string, cfg *model.HCloudK3OSConfig) (err error) {
 		} `yaml:"k3os"`
 	}
 	var (
-		f     *os.File
-		k3cfg = &k3osCfg{}
-		buf   []byte
+		k3cfg   = &k3osCfg{}
+		buf     []byte
+		privnet *model.PrivateNetwork
 	)
+	if privnet, err = cfg.NodeConfig.ClusterNetwork(); err != nil {
+		return fmt.Errorf("error getting cluster network: %w", err)
+	}
 	k3cfg.SSHAuthorizedKeys = cfg.NodeConfig.SSHAuthorizedKeys
 	if cfg.NodeConfig.Role == model.RoleAgent {
 		k3cfg.K3OS.ServerURL = &cfg.ClusterConfig.K3OSMasterJoinURL
@@ -35,7 +38,7 @@ func GenerateK3OSConfig(path string, cfg *model.HCloudK3OSConfig) (err error) {
 			k3cfg.K3OS.K3SArgs,
 			"server",
 			"--advertise-address",
-			cfg.NodeConfig.PrivateNetwork.IPv4Addresses[0].Net.IP.String(),
+			privnet.IPv4Addresses[0].Net.IP.String(),
 			"--disable",
 			"traefik",
 		)
@@ -50,21 +53,15 @@ func GenerateK3OSConfig(path string, cfg *model.HCloudK3OSConfig) (err error) {
 		"--node-name",
 		cfg.NodeConfig.Name,
 		"--node-ip",
-		cfg.NodeConfig.PrivateNetwork.IPv4Addresses[0].Net.IP.String(),
+		privnet.IPv4Addresses[0].Net.IP.String(),
 		"--node-external-ip",
 		cfg.NodeConfig.PublicNetwork.IPv4Addresses[0].Net.IP.String(),
 		"--flannel-iface",
-		cfg.NodeConfig.PrivateNetwork.NetDeviceName,
+		privnet.NetDeviceName,
 	)
 	if buf, err = yaml.Marshal(k3cfg); err != nil {
 		return fmt.Errorf("error marshalling k3os config: %v", err)
 	}
-	if f, err = os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644); err != nil {
-		return fmt.Errorf("error opening output file at \"%s\": %w", path, err)
-	}
-	defer func() {
-		err = f.Close()
-	}()
-	_, err = f.Write(buf)
+	_, err = w.Write(buf)
 	return err
 }